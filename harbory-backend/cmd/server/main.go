@@ -10,22 +10,34 @@ import (
         "syscall"
         "time"
 
+        "github.com/PreetinderSinghBadesha/harbory/internal/backups"
         "github.com/PreetinderSinghBadesha/harbory/internal/config"
+        "github.com/PreetinderSinghBadesha/harbory/internal/credentials"
         "github.com/PreetinderSinghBadesha/harbory/internal/middleware"
         "github.com/PreetinderSinghBadesha/harbory/internal/router"
+        "github.com/PreetinderSinghBadesha/harbory/internal/updates"
 )
 
 func main() {
     cfg := config.MustLoad()
     startTime := time.Now().UTC()
     middleware.InitSessionStore(cfg)
+    if err := credentials.InitStore(cfg); err != nil {
+        slog.Error("Failed to open the registry credential store", "error", err)
+        os.Exit(1)
+    }
+    if err := backups.InitStore(cfg); err != nil {
+        slog.Error("Failed to open the backup directory", "error", err)
+        os.Exit(1)
+    }
+    updates.InitChecker(cfg)
 
-    mux := router.Router(startTime)
+    mux := router.Router(cfg, startTime)
 
     corsHandler := func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
             w.Header().Set("Access-Control-Allow-Origin", "*")
-            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
             w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
             if r.Method == http.MethodOptions {
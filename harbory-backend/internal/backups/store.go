@@ -0,0 +1,266 @@
+// Package backups keeps volume backups on disk: a gzip-compressed tar archive
+// per backup, with a small JSON file next to it recording which volume it
+// came from, its size, its SHA-256 checksum and when it was taken.
+package backups
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/config"
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+const (
+	archiveExt  = ".tar.gz"
+	metadataExt = ".json"
+)
+
+// idPattern matches backup IDs, which are also file names, so an ID can never
+// point outside the backup directory.
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Backup describes a stored backup. Size and SHA256 are those of the
+// compressed archive as it is stored and downloaded.
+type Backup struct {
+	ID        string    `json:"id"`
+	Volume    string    `json:"volume"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store is a directory of backups, safe for concurrent use.
+type Store struct {
+	dir string
+	mu  sync.Mutex // guards ID allocation
+}
+
+var store *Store
+
+// InitStore opens the backup directory from cfg, creating it if needed.
+func InitStore(cfg *config.Config) error {
+	s, err := NewStore(cfg.Storage.BackupDir)
+	if err != nil {
+		return err
+	}
+
+	store = s
+	return nil
+}
+
+func GetStore() *Store {
+	return store
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating backup directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// List returns the backups of volume, or of every volume if volume is empty,
+// newest first. Archives whose metadata is missing or unreadable, such as
+// backups still being written, are left out.
+func (s *Store) List(volume string) ([]Backup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Backup{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), metadataExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		b, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		if volume == "" || b.Volume == volume {
+			list = append(list, b)
+		}
+	}
+
+	slices.SortFunc(list, func(a, b Backup) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return list, nil
+}
+
+// Get returns the backup with the given ID.
+func (s *Store) Get(id string) (Backup, error) {
+	if !idPattern.MatchString(id) {
+		return Backup{}, notFound(id)
+	}
+
+	data, err := os.ReadFile(s.path(id, metadataExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Backup{}, notFound(id)
+	}
+	if err != nil {
+		return Backup{}, err
+	}
+
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Backup{}, fmt.Errorf("reading backup %s: %w", id, err)
+	}
+	return b, nil
+}
+
+// Open returns the backup with the given ID and its archive, which the
+// caller must close.
+func (s *Store) Open(id string) (Backup, *os.File, error) {
+	b, err := s.Get(id)
+	if err != nil {
+		return Backup{}, nil, err
+	}
+
+	f, err := os.Open(s.path(id, archiveExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Backup{}, nil, notFound(id)
+	}
+	if err != nil {
+		return Backup{}, nil, err
+	}
+	return b, f, nil
+}
+
+// Delete removes a backup and its archive.
+func (s *Store) Delete(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	// The metadata goes first, so a failure leaves an archive that List
+	// ignores rather than a listed backup without one.
+	if err := os.Remove(s.path(id, metadataExt)); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id, archiveExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Create starts a new backup of volume. The archive is written to the
+// returned Writer, which must be either committed or aborted.
+func (s *Store) Create(volume string) (*Writer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt := time.Now().UTC()
+	base := volume + "-" + createdAt.Format("20060102T150405Z")
+	// Backups of the same volume taken within a second get a suffix.
+	id := base
+	for n := 2; s.exists(id); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	f, err := os.OpenFile(s.path(id, archiveExt+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		store:  s,
+		file:   f,
+		hash:   sha256.New(),
+		backup: Backup{ID: id, Volume: volume, CreatedAt: createdAt},
+	}, nil
+}
+
+// Filename is the name a backup's archive is downloaded as.
+func (b Backup) Filename() string {
+	return b.ID + archiveExt
+}
+
+// exists reports whether id is taken by a backup, committed or not.
+func (s *Store) exists(id string) bool {
+	for _, ext := range []string{metadataExt, archiveExt, archiveExt + ".tmp"} {
+		if _, err := os.Stat(s.path(id, ext)); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+func notFound(id string) error {
+	return fmt.Errorf("backup %q: %w", id, cerrdefs.ErrNotFound)
+}
+
+// Writer writes a backup's archive, counting and hashing it on the way.
+type Writer struct {
+	store  *Store
+	file   *os.File
+	hash   hash.Hash
+	backup Backup
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.backup.Size += int64(n)
+	return n, err
+}
+
+// ID is the ID the backup will have once committed.
+func (w *Writer) ID() string {
+	return w.backup.ID
+}
+
+// Commit makes the backup visible and returns it.
+func (w *Writer) Commit() (Backup, error) {
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return Backup{}, err
+	}
+	if err := w.file.Close(); err != nil {
+		w.Abort()
+		return Backup{}, err
+	}
+	w.backup.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+
+	if err := os.Rename(w.file.Name(), w.store.path(w.backup.ID, archiveExt)); err != nil {
+		w.Abort()
+		return Backup{}, err
+	}
+
+	data, err := json.MarshalIndent(w.backup, "", "  ")
+	if err != nil {
+		return Backup{}, err
+	}
+	tmp := w.store.path(w.backup.ID, metadataExt+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		_ = os.Remove(w.store.path(w.backup.ID, archiveExt))
+		return Backup{}, err
+	}
+	if err := os.Rename(tmp, w.store.path(w.backup.ID, metadataExt)); err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(w.store.path(w.backup.ID, archiveExt))
+		return Backup{}, err
+	}
+
+	return w.backup, nil
+}
+
+// Abort discards the backup. It is safe to call after Commit has failed.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
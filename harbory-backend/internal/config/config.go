@@ -1,12 +1,18 @@
 package config
 
 import (
+	"log"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type Config struct {
 	HTTPServer HTTPServerConfig
 	Auth       AuthConfig
+	Storage    StorageConfig
+	Updates    UpdatesConfig
+	Helper     HelperConfig
 }
 
 type HTTPServerConfig struct {
@@ -17,6 +23,29 @@ type AuthConfig struct {
 	Password string
 }
 
+// StorageConfig is where Harbory keeps its own state, such as stored
+// registry credentials and volume backups. SecretKey, when set, is used to
+// encrypt secrets at rest instead of a key generated in DataDir.
+type StorageConfig struct {
+	DataDir   string
+	BackupDir string
+	SecretKey string
+}
+
+// UpdatesConfig controls the background check for newer images of running
+// containers. A zero CheckInterval disables the schedule; checks then only
+// run when requested.
+type UpdatesConfig struct {
+	CheckInterval time.Duration
+}
+
+// HelperConfig is the image of the short-lived containers Harbory runs to
+// work on volumes. It needs a shell and tar, and is pulled on first use if
+// the host does not have it.
+type HelperConfig struct {
+	Image string
+}
+
 func MustLoad() *Config {
 	addr := os.Getenv("HTTP_ADDR")
 	if addr == "" {
@@ -28,6 +57,30 @@ func MustLoad() *Config {
 		password = "admin"
 	}
 
+	dataDir := os.Getenv("HARBORY_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	backupDir := os.Getenv("HARBORY_BACKUP_DIR")
+	if backupDir == "" {
+		backupDir = filepath.Join(dataDir, "backups")
+	}
+
+	helperImage := os.Getenv("HARBORY_HELPER_IMAGE")
+	if helperImage == "" {
+		helperImage = "alpine:3.20"
+	}
+
+	checkInterval := 6 * time.Hour
+	if raw := os.Getenv("HARBORY_UPDATE_CHECK_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval < 0 {
+			log.Fatalf("invalid HARBORY_UPDATE_CHECK_INTERVAL %q: must be a duration such as 6h, or 0 to disable", raw)
+		}
+		checkInterval = interval
+	}
+
 	return &Config{
 		HTTPServer: HTTPServerConfig{
 			Addr: addr,
@@ -35,5 +88,16 @@ func MustLoad() *Config {
 		Auth: AuthConfig{
 			Password: password,
 		},
+		Storage: StorageConfig{
+			DataDir:   dataDir,
+			BackupDir: backupDir,
+			SecretKey: os.Getenv("HARBORY_SECRET_KEY"),
+		},
+		Updates: UpdatesConfig{
+			CheckInterval: checkInterval,
+		},
+		Helper: HelperConfig{
+			Image: helperImage,
+		},
 	}
 }
@@ -0,0 +1,77 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Auth is a set of credentials for one registry: a username and password, or
+// an identity (refresh) token.
+type Auth struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// Resolve returns the stored credentials for host: Harbory's own store
+// first, then the Docker CLI's config.json, so registries the Docker host is
+// already logged in to work without any setup. Credential helpers are not
+// consulted.
+func Resolve(host string) (Auth, bool) {
+	if s := GetStore(); s != nil {
+		if entry, ok := s.Lookup(host); ok {
+			return Auth{Username: entry.Username, Password: entry.Secret}, true
+		}
+	}
+	return dockerConfigAuth(host)
+}
+
+func dockerConfigAuth(host string) (Auth, bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Auth{}, false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return Auth{}, false
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Auth{}, false
+	}
+
+	for address, entry := range config.Auths {
+		if NormalizeHost(address) != host {
+			continue
+		}
+
+		auth := Auth{IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				continue
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		if auth.Username == "" && auth.IdentityToken == "" {
+			continue
+		}
+		return auth, true
+	}
+
+	return Auth{}, false
+}
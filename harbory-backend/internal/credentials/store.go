@@ -0,0 +1,394 @@
+// Package credentials keeps the registry credentials Harbory uses to pull
+// and push images. Secrets are encrypted with AES-256-GCM before they are
+// written to disk and are never part of a Registry's JSON encoding.
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/config"
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+const (
+	storeFileName = "registries.json"
+	keyFileName   = "secret.key"
+)
+
+// Registry is a stored registry entry. Host is derived from URL and is what
+// image references are matched against.
+type Registry struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Host      string    `json:"host"`
+	Username  string    `json:"username"`
+	Secret    string    `json:"-"`
+	HasSecret bool      `json:"has_secret"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// storedRegistry is the on-disk form of a Registry, with the secret sealed.
+type storedRegistry struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Username  string    `json:"username"`
+	Secret    string    `json:"secret"` // base64(nonce || ciphertext)
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store is a file-backed set of registry entries, safe for concurrent use.
+type Store struct {
+	path       string
+	aead       cipher.AEAD
+	mu         sync.RWMutex
+	registries []storedRegistry
+}
+
+var store *Store
+
+// InitStore opens the store in cfg's data directory. The encryption key is
+// derived from HARBORY_SECRET_KEY when it is set; otherwise a random key is
+// generated once and kept next to the store.
+func InitStore(cfg *config.Config) error {
+	key, err := loadKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	s, err := NewStore(filepath.Join(cfg.Storage.DataDir, storeFileName), key)
+	if err != nil {
+		return err
+	}
+
+	store = s
+	return nil
+}
+
+func GetStore() *Store {
+	return store
+}
+
+// NewStore opens the store at path, creating it on first write. key must be
+// 32 bytes.
+func NewStore(path string, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("credential store key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path, aead: aead}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.registries); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	// Fail at startup, not on first use, if the key has changed.
+	for _, r := range s.registries {
+		if _, err := s.open(r.Secret); err != nil {
+			return nil, fmt.Errorf("decrypting registry %s: %w", r.ID, err)
+		}
+	}
+
+	return s, nil
+}
+
+// List returns all entries ordered by host, without their secrets.
+func (s *Store) List() []Registry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Registry, 0, len(s.registries))
+	for _, r := range s.registries {
+		list = append(list, r.public())
+	}
+	slices.SortFunc(list, func(a, b Registry) int { return strings.Compare(a.Host, b.Host) })
+	return list
+}
+
+// Get returns the entry with the given ID, without its secret.
+func (s *Store) Get(id string) (Registry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return Registry{}, notFound(id)
+	}
+	return s.registries[i].public(), nil
+}
+
+// Lookup returns the entry for host, including its decrypted secret.
+func (s *Store) Lookup(host string) (Registry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.registries {
+		if NormalizeHost(r.URL) != host {
+			continue
+		}
+		secret, err := s.open(r.Secret)
+		if err != nil {
+			return Registry{}, false
+		}
+		entry := r.public()
+		entry.Secret = secret
+		return entry, true
+	}
+	return Registry{}, false
+}
+
+// Create adds an entry. Only one entry per host is allowed.
+func (s *Store) Create(url, username, secret string) (Registry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hostIndex(NormalizeHost(url), "") >= 0 {
+		return Registry{}, fmt.Errorf("a registry for %s already exists: %w", NormalizeHost(url), cerrdefs.ErrAlreadyExists)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Registry{}, err
+	}
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return Registry{}, err
+	}
+
+	now := time.Now().UTC()
+	r := storedRegistry{
+		ID:        id,
+		URL:       url,
+		Username:  username,
+		Secret:    sealed,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := s.save(append(slices.Clone(s.registries), r)); err != nil {
+		return Registry{}, err
+	}
+	return r.public(), nil
+}
+
+// Update replaces an entry's URL and username. A nil secret keeps the stored
+// one.
+func (s *Store) Update(id, url, username string, secret *string) (Registry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return Registry{}, notFound(id)
+	}
+	if s.hostIndex(NormalizeHost(url), id) >= 0 {
+		return Registry{}, fmt.Errorf("a registry for %s already exists: %w", NormalizeHost(url), cerrdefs.ErrAlreadyExists)
+	}
+
+	registries := slices.Clone(s.registries)
+	r := &registries[i]
+	r.URL = url
+	r.Username = username
+	r.UpdatedAt = time.Now().UTC()
+	if secret != nil {
+		sealed, err := s.seal(*secret)
+		if err != nil {
+			return Registry{}, err
+		}
+		r.Secret = sealed
+	}
+
+	if err := s.save(registries); err != nil {
+		return Registry{}, err
+	}
+	return r.public(), nil
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return notFound(id)
+	}
+	return s.save(slices.Delete(slices.Clone(s.registries), i, i+1))
+}
+
+// NormalizeHost reduces a registry URL or server address to the domain used
+// in image references, so "https://index.docker.io/v1/" and "docker.io"
+// compare equal.
+func NormalizeHost(address string) string {
+	address = strings.TrimPrefix(address, "https://")
+	address = strings.TrimPrefix(address, "http://")
+	address, _, _ = strings.Cut(address, "/")
+	address = strings.ToLower(address)
+
+	switch address {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return address
+}
+
+func (r storedRegistry) public() Registry {
+	return Registry{
+		ID:        r.ID,
+		URL:       r.URL,
+		Host:      NormalizeHost(r.URL),
+		Username:  r.Username,
+		HasSecret: r.Secret != "",
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
+
+func (s *Store) index(id string) int {
+	return slices.IndexFunc(s.registries, func(r storedRegistry) bool { return r.ID == id })
+}
+
+// hostIndex finds the entry for host, ignoring the entry with ID except.
+func (s *Store) hostIndex(host, except string) int {
+	return slices.IndexFunc(s.registries, func(r storedRegistry) bool {
+		return r.ID != except && NormalizeHost(r.URL) == host
+	})
+}
+
+// save writes registries to disk and, once that succeeded, makes them the
+// current set. The file is replaced atomically.
+func (s *Store) save(registries []storedRegistry) error {
+	data, err := json.MarshalIndent(registries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), storeFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	s.registries = registries
+	return nil
+}
+
+func (s *Store) seal(secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) open(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < s.aead.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// loadKey returns the 32-byte store key: a SHA-256 of HARBORY_SECRET_KEY, or
+// the random key kept in the data directory, generated on first start.
+func loadKey(cfg *config.Config) ([]byte, error) {
+	if cfg.Storage.SecretKey != "" {
+		sum := sha256.Sum256([]byte(cfg.Storage.SecretKey))
+		return sum[:], nil
+	}
+
+	path := filepath.Join(cfg.Storage.DataDir, keyFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s does not hold a 32-byte hex key", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Storage.DataDir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func notFound(id string) error {
+	return fmt.Errorf("registry %q: %w", id, cerrdefs.ErrNotFound)
+}
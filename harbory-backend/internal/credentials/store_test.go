@@ -0,0 +1,99 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSecret = "s3cret-password"
+
+// newTestStore returns the path of a store holding one registry entry,
+// sealed with key.
+func newTestStore(t *testing.T, key []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), storeFileName)
+	s, err := NewStore(path, key)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := s.Create("https://registry.example.com", "alice", testSecret); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return path
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	key := testKey(1)
+	path := newTestStore(t, key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(testSecret)) {
+		t.Fatalf("%s holds the secret in plain text", path)
+	}
+
+	s, err := NewStore(path, key)
+	if err != nil {
+		t.Fatalf("reopening the store: %v", err)
+	}
+	entry, ok := s.Lookup("registry.example.com")
+	if !ok {
+		t.Fatal("Lookup found no entry after reopening the store")
+	}
+	if entry.Username != "alice" || entry.Secret != testSecret {
+		t.Fatalf("Lookup = %q/%q, want alice/%q", entry.Username, entry.Secret, testSecret)
+	}
+	if list := s.List(); len(list) != 1 || list[0].Secret != "" || !list[0].HasSecret {
+		t.Fatalf("List = %+v, want one entry with its secret withheld", list)
+	}
+}
+
+func TestStoreDetectsTampering(t *testing.T) {
+	key := testKey(1)
+	path := newTestStore(t, key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registries []storedRegistry
+	if err := json.Unmarshal(data, &registries); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(registries[0].Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 0xff
+	registries[0].Secret = base64.StdEncoding.EncodeToString(sealed)
+	data, err = json.Marshal(registries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStore(path, key); err == nil {
+		t.Fatal("NewStore opened a store with a tampered secret")
+	}
+}
+
+func TestStoreRejectsWrongKey(t *testing.T) {
+	path := newTestStore(t, testKey(1))
+
+	if _, err := NewStore(path, testKey(2)); err == nil {
+		t.Fatal("NewStore opened a store with the wrong key")
+	}
+}
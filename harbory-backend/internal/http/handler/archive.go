@@ -0,0 +1,625 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// maxDirectoryEntries caps a single directory listing.
+	maxDirectoryEntries = 5000
+
+	// maxSymlinkHops bounds symlink resolution, like the kernel's ELOOP limit.
+	maxSymlinkHops = 16
+
+	// maxUploadBytes caps the request body of a file upload and
+	// uploadMemoryBytes is how much of it is held in memory before the
+	// multipart parser spills to temporary files.
+	maxUploadBytes    = 1 << 30
+	uploadMemoryBytes = 32 << 20
+)
+
+type FileEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Type       string    `json:"type"` // "file", "dir", "symlink" or "other"
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"`
+	UID        int       `json:"uid"`
+	GID        int       `json:"gid"`
+	ModTime    time.Time `json:"mod_time"`
+	LinkTarget string    `json:"link_target,omitempty"`
+}
+
+type DirectoryListing struct {
+	Path      string      `json:"path"`
+	Entries   []FileEntry `json:"entries"`
+	Truncated bool        `json:"truncated"`
+}
+
+type UploadResponse struct {
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+}
+
+// cleanArchivePath turns a user supplied path into a clean absolute path.
+// Relative paths are taken from the root, so ".." can never climb above it.
+func cleanArchivePath(raw string) (string, error) {
+	if raw == "" {
+		return "", invalidParameter(errors.New("path is required"))
+	}
+	if strings.ContainsRune(raw, 0) {
+		return "", invalidParameter(errors.New("path must not contain NUL bytes"))
+	}
+	return path.Clean("/" + raw), nil
+}
+
+// resolveContainerPath follows symlinks until p names something that is not
+// a link. Docker resolves every link inside the container's root filesystem,
+// so the result can never point outside of it.
+func resolveContainerPath(ctx context.Context, cli *client.Client, containerID, p string) (string, container.PathStat, error) {
+	for hops := 0; ; hops++ {
+		stat, err := cli.ContainerStatPath(ctx, containerID, p)
+		if err != nil {
+			return "", stat, err
+		}
+		if stat.Mode&os.ModeSymlink == 0 {
+			return p, stat, nil
+		}
+		if hops == maxSymlinkHops {
+			return "", stat, invalidParameter(fmt.Errorf("too many levels of symbolic links resolving %s", p))
+		}
+
+		target := stat.LinkTarget
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = path.Clean(target)
+	}
+}
+
+// statListFormat is the stat(1) format of listDirectoryCommand: the raw mode
+// in hex, size, owner, modification time and path.
+const statListFormat = "%f %s %u %g %Y %n"
+
+// maxListingOutput bounds the stat output kept for one listing, well above
+// what maxDirectoryEntries lines take unless names are very long.
+const maxListingOutput = maxDirectoryEntries * 512
+
+// listDirectoryCommand prints a stat line, in statListFormat, for each direct
+// child of dir. It needs find and stat, which both busybox and GNU provide.
+// Its output is capped while reading rather than by piping it into head,
+// which would have find report stat being killed by SIGPIPE.
+func listDirectoryCommand(dir string) []string {
+	return []string{"find", dir, "-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", statListFormat, "{}", "+"}
+}
+
+// listContainerDirectory lists the direct children of dir. In a running
+// container it runs listDirectoryCommand through exec, so only that one
+// directory is read; unlike the rest of the file browser, this runs a
+// command inside the user's container, as its own user. Stopped containers,
+// and images without find and stat, can only be read through the archive
+// API, which walks the whole subtree.
+func listContainerDirectory(ctx context.Context, cli *client.Client, containerID, dir string) (DirectoryListing, error) {
+	resolved, stat, err := resolveContainerPath(ctx, cli, containerID, dir)
+	if err != nil {
+		return DirectoryListing{}, err
+	}
+	if !stat.Mode.IsDir() {
+		return DirectoryListing{}, invalidParameter(fmt.Errorf("%s is not a directory", resolved))
+	}
+
+	listing := DirectoryListing{Path: resolved}
+	if output, ok := execListDirectory(ctx, cli, containerID, resolved); ok {
+		listing.Entries, listing.Truncated = output.parse(resolved)
+		fillLinkTargets(ctx, cli, containerID, listing.Entries)
+	} else if listing, err = archiveListDirectory(ctx, cli, containerID, resolved); err != nil {
+		return listing, err
+	}
+
+	sortDirectoryEntries(listing.Entries)
+	return listing, nil
+}
+
+// execListDirectory runs listDirectoryCommand in a running container. It
+// reports false when the container is not running or the command fails,
+// including when the container's user may not read everything in dir.
+func execListDirectory(ctx context.Context, cli *client.Client, containerID, dir string) (*listingBuffer, bool) {
+	info, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil || info.State == nil || !info.State.Running || info.State.Paused {
+		return nil, false
+	}
+
+	exec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          listDirectoryCommand(dir),
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, false
+	}
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return nil, false
+	}
+	defer attach.Close()
+
+	var stdout listingBuffer
+	var stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader); err != nil || stderr.Len() > 0 {
+		return nil, false
+	}
+
+	// The output can end just before the daemon records the exit code.
+	for range 20 {
+		inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return nil, false
+		}
+		if !inspect.Running {
+			return &stdout, inspect.ExitCode == 0
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	return nil, false
+}
+
+// listingBuffer keeps the first maxListingOutput bytes of a listing and
+// drops the rest, still accepting them so the command runs to completion.
+type listingBuffer struct {
+	bytes.Buffer
+	dropped bool
+}
+
+func (b *listingBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := maxListingOutput - b.Len(); n > room {
+		p = p[:room]
+		b.dropped = true
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// parse parses the complete lines kept, as listed from dir.
+func (b *listingBuffer) parse(dir string) ([]FileEntry, bool) {
+	output := b.Bytes()
+	if b.dropped {
+		output = output[:bytes.LastIndexByte(output, '\n')+1]
+	}
+	entries, truncated := parseDirectoryListing(output, dir)
+	return entries, truncated || b.dropped
+}
+
+// parseDirectoryListing turns the output of listDirectoryCommand run on dir
+// into entries. Lines that do not parse, such as the pieces of a name with
+// a newline in it, are skipped.
+func parseDirectoryListing(output []byte, dir string) ([]FileEntry, bool) {
+	entries := []FileEntry{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.SplitN(line, " ", 6)
+		if len(fields) != 6 || path.Dir(fields[5]) != dir {
+			continue
+		}
+		raw, err1 := strconv.ParseUint(fields[0], 16, 32)
+		size, err2 := strconv.ParseInt(fields[1], 10, 64)
+		uid, err3 := strconv.Atoi(fields[2])
+		gid, err4 := strconv.Atoi(fields[3])
+		mtime, err5 := strconv.ParseInt(fields[4], 10, 64)
+		if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+			continue
+		}
+
+		if len(entries) == maxDirectoryEntries {
+			return entries, true
+		}
+		mode, typ := unixFileMode(uint32(raw))
+		entries = append(entries, FileEntry{
+			Name:    path.Base(fields[5]),
+			Path:    fields[5],
+			Type:    typ,
+			Size:    size,
+			Mode:    mode.String(),
+			UID:     uid,
+			GID:     gid,
+			ModTime: time.Unix(mtime, 0).UTC(),
+		})
+	}
+	return entries, false
+}
+
+// unixFileMode converts a raw st_mode into an os.FileMode and the entry type
+// FileEntry reports.
+func unixFileMode(raw uint32) (os.FileMode, string) {
+	mode := os.FileMode(raw & 0o777)
+	if raw&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if raw&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if raw&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch raw & 0o170000 {
+	case 0o040000:
+		return mode | os.ModeDir, "dir"
+	case 0o100000:
+		return mode, "file"
+	case 0o120000:
+		return mode | os.ModeSymlink, "symlink"
+	case 0o020000:
+		return mode | os.ModeDevice | os.ModeCharDevice, "other"
+	case 0o060000:
+		return mode | os.ModeDevice, "other"
+	case 0o010000:
+		return mode | os.ModeNamedPipe, "other"
+	case 0o140000:
+		return mode | os.ModeSocket, "other"
+	default:
+		return mode, "other"
+	}
+}
+
+// fillLinkTargets looks up the targets of the symlinks among entries, which
+// stat's listing format does not include.
+func fillLinkTargets(ctx context.Context, cli *client.Client, containerID string, entries []FileEntry) {
+	for i := range entries {
+		if entries[i].Type != "symlink" {
+			continue
+		}
+		if stat, err := cli.ContainerStatPath(ctx, containerID, entries[i].Path); err == nil {
+			entries[i].LinkTarget = stat.LinkTarget
+		}
+	}
+}
+
+// archiveListDirectory lists the direct children of dir, which must be
+// resolved, by walking the tar stream Docker returns for it. Entries that
+// would escape the archive root are ignored.
+func archiveListDirectory(ctx context.Context, cli *client.Client, containerID, dir string) (DirectoryListing, error) {
+	content, _, err := cli.CopyFromContainer(ctx, containerID, dir)
+	if err != nil {
+		return DirectoryListing{}, err
+	}
+	defer content.Close()
+
+	listing := DirectoryListing{Path: dir, Entries: []FileEntry{}}
+	tr := tar.NewReader(content)
+	root := ""
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return listing, err
+		}
+
+		name, ok := safeArchiveName(hdr.Name)
+		if !ok {
+			continue
+		}
+		if root == "" {
+			root = name
+			continue
+		}
+		if name == root || path.Dir(name) != root {
+			continue
+		}
+
+		if len(listing.Entries) == maxDirectoryEntries {
+			listing.Truncated = true
+			break
+		}
+		listing.Entries = append(listing.Entries, fileEntryFromHeader(hdr, path.Join(dir, path.Base(name))))
+	}
+
+	return listing, nil
+}
+
+// sortDirectoryEntries puts directories first, then orders by name.
+func sortDirectoryEntries(entries []FileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if (a.Type == "dir") != (b.Type == "dir") {
+			return a.Type == "dir"
+		}
+		return a.Name < b.Name
+	})
+}
+
+func fileEntryFromHeader(hdr *tar.Header, fullPath string) FileEntry {
+	entry := FileEntry{
+		Name:    path.Base(fullPath),
+		Path:    fullPath,
+		Size:    hdr.Size,
+		Mode:    hdr.FileInfo().Mode().String(),
+		UID:     hdr.Uid,
+		GID:     hdr.Gid,
+		ModTime: hdr.ModTime,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		entry.Type = "dir"
+	case tar.TypeReg, tar.TypeLink:
+		entry.Type = "file"
+	case tar.TypeSymlink:
+		entry.Type = "symlink"
+		entry.LinkTarget = hdr.Linkname
+	default:
+		entry.Type = "other"
+	}
+
+	return entry
+}
+
+// safeArchiveName cleans a tar entry name and reports whether it stays
+// inside the archive root.
+func safeArchiveName(name string) (string, bool) {
+	cleaned := path.Clean(name)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// containerDownload is a file or directory read from a container, opened so
+// that lookup errors can still be reported with a proper status before any
+// of the response is written.
+type containerDownload struct {
+	content io.ReadCloser
+	tr      *tar.Reader
+	name    string
+	isDir   bool
+	hdr     *tar.Header
+}
+
+func openContainerDownload(ctx context.Context, cli *client.Client, containerID, p string) (*containerDownload, error) {
+	resolved, stat, err := resolveContainerPath(ctx, cli, containerID, p)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode.IsDir() && !stat.Mode.IsRegular() {
+		return nil, invalidParameter(fmt.Errorf("%s is not a regular file or directory", resolved))
+	}
+
+	content, _, err := cli.CopyFromContainer(ctx, containerID, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &containerDownload{
+		content: content,
+		tr:      tar.NewReader(content),
+		name:    path.Base(resolved),
+		isDir:   stat.Mode.IsDir(),
+	}
+	if d.name == "/" {
+		d.name = "root"
+	}
+
+	if !d.isDir {
+		d.hdr, err = d.tr.Next()
+		if err != nil {
+			content.Close()
+			return nil, err
+		}
+		if d.hdr.Typeflag != tar.TypeReg {
+			content.Close()
+			return nil, invalidParameter(fmt.Errorf("%s is not a regular file", resolved))
+		}
+	}
+
+	return d, nil
+}
+
+// Stream writes a regular file as-is and a directory as a tar.gz archive.
+func (d *containerDownload) Stream(w http.ResponseWriter) error {
+	_ = stream.DisableWriteDeadline(w)
+
+	if !d.isDir {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(d.hdr.Size, 10))
+		w.Header().Set("Content-Disposition", attachmentDisposition(d.name))
+		w.WriteHeader(http.StatusOK)
+
+		_, err := io.Copy(w, d.tr)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", attachmentDisposition(d.name+".tar.gz"))
+	w.WriteHeader(http.StatusOK)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := copySafeTar(tw, d.tr); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (d *containerDownload) Close() error {
+	return d.content.Close()
+}
+
+// copySafeTar re-writes a tar stream, dropping entries that would extract
+// outside the destination: absolute names, ".." components, hard links to
+// such names, and anything placed underneath a symlink.
+func copySafeTar(tw *tar.Writer, tr *tar.Reader) error {
+	symlinks := map[string]bool{}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := safeArchiveName(hdr.Name)
+		if !ok || underSymlink(name, symlinks) {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if _, ok := safeArchiveName(hdr.Linkname); !ok {
+				continue
+			}
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			symlinks[name] = true
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		hdr.Name = name
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func underSymlink(name string, symlinks map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadToContainer writes the uploaded files into dir as a tar stream. Only
+// the base name of each upload is used, so clients cannot choose where in the
+// container a file lands beyond the target directory.
+func uploadToContainer(ctx context.Context, cli *client.Client, containerID, dir string, files []*multipart.FileHeader) (UploadResponse, error) {
+	resolved, stat, err := resolveContainerPath(ctx, cli, containerID, dir)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if !stat.Mode.IsDir() {
+		return UploadResponse{}, invalidParameter(fmt.Errorf("%s is not a directory", resolved))
+	}
+
+	return copyUploads(ctx, cli, containerID, resolved, files, 0, 0)
+}
+
+// copyUploads writes the uploaded files, owned by uid:gid, into dir, which
+// must already be resolved.
+func copyUploads(ctx context.Context, cli *client.Client, containerID, dir string, files []*multipart.FileHeader, uid, gid int) (UploadResponse, error) {
+	names := make([]string, 0, len(files))
+	for _, fh := range files {
+		name, err := sanitizeUploadName(fh.Filename)
+		if err != nil {
+			return UploadResponse{}, err
+		}
+		names = append(names, name)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeUploadTar(pw, files, names, uid, gid))
+	}()
+
+	err := cli.CopyToContainer(ctx, containerID, dir, pr, container.CopyToContainerOptions{})
+	pr.CloseWithError(err)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	return UploadResponse{Path: dir, Files: names}, nil
+}
+
+func writeUploadTar(w io.Writer, files []*multipart.FileHeader, names []string, uid, gid int) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+
+	for i, fh := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     names[i],
+			Mode:     0o644,
+			Size:     fh.Size,
+			Uid:      uid,
+			Gid:      gid,
+			ModTime:  now,
+		}); err != nil {
+			return err
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func sanitizeUploadName(filename string) (string, error) {
+	name := filename
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, 0) {
+		return "", invalidParameter(fmt.Errorf("invalid file name %q", filename))
+	}
+	return name, nil
+}
+
+// parseUploadForm lifts the server timeouts for the upload, bounds its size
+// and parses the multipart form. Callers must call RemoveAll on the form.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) (*multipart.Form, error) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		return nil, invalidParameter(fmt.Errorf("invalid upload: %w", err))
+	}
+	if len(r.MultipartForm.File["file"]) == 0 {
+		r.MultipartForm.RemoveAll()
+		return nil, invalidParameter(errors.New("no files uploaded in the \"file\" field"))
+	}
+	return r.MultipartForm, nil
+}
+
+func attachmentDisposition(filename string) string {
+	return fmt.Sprintf("attachment; filename=%q", filename)
+}
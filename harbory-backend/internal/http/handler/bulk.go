@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// bulkConcurrency caps how many Docker calls a bulk request makes at once.
+const bulkConcurrency = 4
+
+// BulkRequest selects resources by ID (or name) and/or by label. When both
+// are given, only the listed IDs that also match the labels are affected.
+type BulkRequest struct {
+	Action        string   `json:"action"`
+	IDs           []string `json:"ids,omitempty"`
+	Labels        []string `json:"labels,omitempty"` // "key" or "key=value"; all must match
+	Force         bool     `json:"force,omitempty"`
+	RemoveVolumes bool     `json:"remove_volumes,omitempty"` // containers only
+	Timeout       *int     `json:"timeout,omitempty"`        // stop/restart, seconds
+	DryRun        bool     `json:"dry_run,omitempty"`
+}
+
+type BulkResult struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"` // "ok", "error", or "pending" in a dry run
+	Error  string `json:"error,omitempty"`
+}
+
+type BulkResponse struct {
+	Action    string       `json:"action"`
+	DryRun    bool         `json:"dry_run"`
+	Results   []BulkResult `json:"results"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+// bulkTarget is a resource a bulk request can act on. Names holds whatever
+// else the resource may be referred to by: container names or image tags.
+type bulkTarget struct {
+	ID    string
+	Names []string
+}
+
+type bulkAction func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error
+
+// bulkResource lists the resources of one type, narrowed by the request's
+// labels as Docker filters, and the actions a bulk request may run on them.
+type bulkResource struct {
+	list    func(ctx context.Context, cli *client.Client, args filters.Args) ([]bulkTarget, error)
+	actions map[string]bulkAction
+}
+
+// BulkContainersHandler starts, stops, restarts or removes several containers.
+func BulkContainersHandler() http.HandlerFunc {
+	return bulkHandler(bulkResource{
+		list: func(ctx context.Context, cli *client.Client, args filters.Args) ([]bulkTarget, error) {
+			containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+			if err != nil {
+				return nil, err
+			}
+			containers = withoutHelpers(containers)
+			targets := make([]bulkTarget, 0, len(containers))
+			for _, c := range containers {
+				targets = append(targets, bulkTarget{ID: c.ID, Names: containerNames(c)})
+			}
+			return targets, nil
+		},
+		actions: map[string]bulkAction{
+			"start": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.ContainerStart(ctx, id, container.StartOptions{})
+			},
+			"stop": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.ContainerStop(ctx, id, container.StopOptions{Timeout: req.Timeout})
+			},
+			"restart": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.ContainerRestart(ctx, id, container.StopOptions{Timeout: req.Timeout})
+			},
+			"remove": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: req.Force, RemoveVolumes: req.RemoveVolumes})
+			},
+		},
+	})
+}
+
+// BulkImagesHandler removes several images.
+func BulkImagesHandler() http.HandlerFunc {
+	return bulkHandler(bulkResource{
+		list: func(ctx context.Context, cli *client.Client, args filters.Args) ([]bulkTarget, error) {
+			images, err := cli.ImageList(ctx, image.ListOptions{Filters: args})
+			if err != nil {
+				return nil, err
+			}
+			targets := make([]bulkTarget, 0, len(images))
+			for _, i := range images {
+				targets = append(targets, bulkTarget{ID: i.ID, Names: imageTags(i)})
+			}
+			return targets, nil
+		},
+		actions: map[string]bulkAction{
+			"remove": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				_, err := cli.ImageRemove(ctx, id, image.RemoveOptions{Force: req.Force, PruneChildren: true})
+				return err
+			},
+		},
+	})
+}
+
+// BulkVolumesHandler removes several volumes.
+func BulkVolumesHandler() http.HandlerFunc {
+	return bulkHandler(bulkResource{
+		list: func(ctx context.Context, cli *client.Client, args filters.Args) ([]bulkTarget, error) {
+			volumes, err := cli.VolumeList(ctx, volume.ListOptions{Filters: args})
+			if err != nil {
+				return nil, err
+			}
+			targets := make([]bulkTarget, 0, len(volumes.Volumes))
+			for _, v := range volumes.Volumes {
+				targets = append(targets, bulkTarget{ID: v.Name})
+			}
+			return targets, nil
+		},
+		actions: map[string]bulkAction{
+			"remove": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.VolumeRemove(ctx, id, req.Force)
+			},
+		},
+	})
+}
+
+// BulkNetworksHandler removes several networks.
+func BulkNetworksHandler() http.HandlerFunc {
+	return bulkHandler(bulkResource{
+		list: func(ctx context.Context, cli *client.Client, args filters.Args) ([]bulkTarget, error) {
+			networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: args})
+			if err != nil {
+				return nil, err
+			}
+			targets := make([]bulkTarget, 0, len(networks))
+			for _, n := range networks {
+				targets = append(targets, bulkTarget{ID: n.ID, Names: []string{n.Name}})
+			}
+			return targets, nil
+		},
+		actions: map[string]bulkAction{
+			"remove": func(ctx context.Context, cli *client.Client, id string, req BulkRequest) error {
+				return cli.NetworkRemove(ctx, id)
+			},
+		},
+	})
+}
+
+// bulkHandler resolves the request to a set of resources and runs the action
+// on each of them. Failures are reported per resource, so the response is 200
+// even when some (or all) of them failed; a dry run only lists the targets.
+func bulkHandler(resource bulkResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if fieldErrs := req.validate(resource); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		args := filters.NewArgs()
+		for _, label := range req.Labels {
+			args.Add("label", label)
+		}
+		targets, err := resource.list(r.Context(), cli, args)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := BulkResponse{Action: req.Action, DryRun: req.DryRun}
+		resp.Results = resolveBulkTargets(req, targets)
+
+		// Stopping a batch of containers can take far longer than the
+		// server's write timeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		action := resource.actions[req.Action]
+		sem := make(chan struct{}, bulkConcurrency)
+		var wg sync.WaitGroup
+		for i := range resp.Results {
+			result := &resp.Results[i]
+			if result.Status != "" {
+				continue
+			}
+			if req.DryRun {
+				result.Status = "pending"
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				if err := action(r.Context(), cli, result.ID, req); err != nil {
+					result.Status = "error"
+					result.Error = err.Error()
+					return
+				}
+				result.Status = "ok"
+			}()
+		}
+		wg.Wait()
+
+		for _, result := range resp.Results {
+			switch result.Status {
+			case "ok":
+				resp.Succeeded++
+			case "error":
+				resp.Failed++
+			}
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+func (req BulkRequest) validate(resource bulkResource) []response.FieldError {
+	var errs []response.FieldError
+
+	if _, ok := resource.actions[req.Action]; !ok {
+		errs = append(errs, response.FieldError{
+			Field:   "action",
+			Message: "must be one of " + strings.Join(sortedKeys(resource.actions), ", "),
+		})
+	}
+	if len(req.IDs) == 0 && len(req.Labels) == 0 {
+		errs = append(errs, response.FieldError{Field: "ids", Message: "ids or labels are required"})
+	}
+	for i, label := range req.Labels {
+		if key, _, _ := strings.Cut(label, "="); key == "" {
+			errs = append(errs, response.FieldError{Field: fmt.Sprintf("labels[%d]", i), Message: "must be key or key=value"})
+		}
+	}
+	if req.Timeout != nil && *req.Timeout < -1 {
+		errs = append(errs, response.FieldError{Field: "timeout", Message: "must be -1 (wait forever) or a number of seconds"})
+	}
+
+	return errs
+}
+
+// resolveBulkTargets returns one result per resource to act on. Without IDs
+// every target is selected. Each ID is matched against full IDs and names
+// first, then as an ID prefix; IDs that match nothing or more than one
+// resource come back as errors, with Status already set.
+func resolveBulkTargets(req BulkRequest, targets []bulkTarget) []BulkResult {
+	results := make([]BulkResult, 0, max(len(req.IDs), len(targets)))
+	seen := make(map[string]bool)
+	add := func(t bulkTarget) {
+		if seen[t.ID] {
+			return
+		}
+		seen[t.ID] = true
+		result := BulkResult{ID: t.ID}
+		if len(t.Names) > 0 {
+			result.Name = t.Names[0]
+		}
+		results = append(results, result)
+	}
+
+	if len(req.IDs) == 0 {
+		for _, t := range targets {
+			add(t)
+		}
+		return results
+	}
+
+	for _, ref := range req.IDs {
+		matched := matchBulkTargets(ref, targets)
+		switch len(matched) {
+		case 1:
+			add(matched[0])
+		case 0:
+			results = append(results, BulkResult{ID: ref, Status: "error", Error: "not found"})
+		default:
+			results = append(results, BulkResult{ID: ref, Status: "error", Error: fmt.Sprintf("ambiguous: matches %d resources", len(matched))})
+		}
+	}
+
+	return results
+}
+
+func matchBulkTargets(ref string, targets []bulkTarget) []bulkTarget {
+	var exact, prefix []bulkTarget
+	shortRef := strings.TrimPrefix(ref, "sha256:")
+
+	for _, t := range targets {
+		id := strings.TrimPrefix(t.ID, "sha256:")
+		switch {
+		case id == shortRef:
+			exact = append(exact, t)
+		case slices.Contains(t.Names, ref):
+			exact = append(exact, t)
+		case shortRef != "" && strings.HasPrefix(id, shortRef):
+			prefix = append(prefix, t)
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return prefix
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+// autoShellCmd starts bash when the image has it and falls back to sh.
+var autoShellCmd = []string{"/bin/sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"}
+
+type ExecMessage struct {
+	Type     string `json:"type"` // client: "input", "resize"; server: "output", "exit", "error"
+	Data     string `json:"data,omitempty"`
+	Cols     uint   `json:"cols,omitempty"`
+	Rows     uint   `json:"rows,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// ContainerExecWebSocketHandler opens an interactive TTY session inside a
+// running container. The "shell", "user", "workdir", "cols" and "rows" query
+// parameters configure the exec instance; after the upgrade the client sends
+// "input" and "resize" messages and receives "output" until an "exit".
+func ContainerExecWebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		options, err := execOptionsFromQuery(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		containerID := r.PathValue("id")
+		info, err := cli.ContainerInspect(r.Context(), containerID)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if info.State == nil || !info.State.Running {
+			response.SendError(w, http.StatusConflict, fmt.Sprintf("container %s is not running", containerID))
+			return
+		}
+
+		websocket.Handler(func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			if err := runExecSession(ws, cli, info.ID, options); err != nil {
+				sendExecMessage(ws, ExecMessage{Type: "error", Message: err.Error()})
+			}
+		}).ServeHTTP(w, r)
+	})
+}
+
+// runExecSession creates the exec instance, then pumps output to the
+// WebSocket while applying input and resize messages from it. It returns
+// once the process exits or the client disconnects.
+func runExecSession(ws *websocket.Conn, cli *client.Client, containerID string, options container.ExecOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	created, err := cli.ContainerExecCreate(ctx, containerID, options)
+	if err != nil {
+		return fmt.Errorf("failed to create exec instance: %w", err)
+	}
+
+	hijacked, err := cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{
+		Tty:         true,
+		ConsoleSize: options.ConsoleSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to exec instance: %w", err)
+	}
+	defer hijacked.Close()
+
+	outputDone := make(chan error, 1)
+	go func() {
+		outputDone <- pumpExecOutput(ws, hijacked.Reader)
+	}()
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- pumpExecInput(ctx, ws, cli, created.ID, hijacked.Conn)
+	}()
+
+	select {
+	case err := <-outputDone:
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case <-inputDone:
+		// The client went away; closing the hijacked connection on return
+		// hangs up the TTY and lets the shell exit.
+		return nil
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect exec instance: %w", err)
+	}
+	exitCode := inspect.ExitCode
+	sendExecMessage(ws, ExecMessage{Type: "exit", ExitCode: &exitCode})
+	return nil
+}
+
+// pumpExecOutput forwards TTY output as "output" messages. A multi-byte UTF-8
+// sequence split across reads is held back until it is complete so JSON
+// encoding never mangles it.
+func pumpExecOutput(ws *websocket.Conn, output io.Reader) error {
+	buf := make([]byte, 32*1024)
+	var pending []byte
+
+	for {
+		n, err := output.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			cut := completeUTF8Prefix(pending)
+			if cut > 0 {
+				if sendErr := websocket.JSON.Send(ws, ExecMessage{Type: "output", Data: string(pending[:cut])}); sendErr != nil {
+					return sendErr
+				}
+				pending = append(pending[:0], pending[cut:]...)
+			}
+		}
+		if err != nil {
+			if len(pending) > 0 {
+				_ = websocket.JSON.Send(ws, ExecMessage{Type: "output", Data: string(pending)})
+			}
+			return err
+		}
+	}
+}
+
+// pumpExecInput applies client messages to the exec instance until the
+// WebSocket is closed.
+func pumpExecInput(ctx context.Context, ws *websocket.Conn, cli *client.Client, execID string, input io.Writer) error {
+	for {
+		var msg ExecMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case "input":
+			if _, err := io.WriteString(input, msg.Data); err != nil {
+				return err
+			}
+		case "resize":
+			if msg.Cols == 0 || msg.Rows == 0 {
+				continue
+			}
+			if err := cli.ContainerExecResize(ctx, execID, container.ResizeOptions{
+				Height: msg.Rows,
+				Width:  msg.Cols,
+			}); err != nil {
+				sendExecMessage(ws, ExecMessage{Type: "error", Message: "Resize failed: " + err.Error()})
+			}
+		default:
+			sendExecMessage(ws, ExecMessage{Type: "error", Message: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+	}
+}
+
+// completeUTF8Prefix returns the length of the longest prefix of b that does
+// not end in the middle of a UTF-8 sequence.
+func completeUTF8Prefix(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if utf8.FullRune(b[i:]) {
+			return len(b)
+		}
+		return i
+	}
+	return len(b)
+}
+
+// execOptionsFromQuery builds the exec configuration from the shell, user,
+// workdir, cols and rows query parameters. Without a shell Harbory prefers
+// bash and falls back to sh.
+func execOptionsFromQuery(r *http.Request) (container.ExecOptions, error) {
+	query := r.URL.Query()
+	options := container.ExecOptions{
+		User:         query.Get("user"),
+		WorkingDir:   query.Get("workdir"),
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+		Cmd:          autoShellCmd,
+	}
+
+	if shell := query.Get("shell"); shell != "" {
+		options.Cmd = []string{shell}
+	}
+
+	cols, err := queryUint(r, "cols")
+	if err != nil {
+		return options, err
+	}
+	rows, err := queryUint(r, "rows")
+	if err != nil {
+		return options, err
+	}
+	if cols > 0 && rows > 0 {
+		options.ConsoleSize = &[2]uint{rows, cols}
+	}
+
+	return options, nil
+}
+
+func sendExecMessage(ws *websocket.Conn, msg ExecMessage) {
+	if err := websocket.JSON.Send(ws, msg); err != nil {
+		log.Printf("Error sending exec message: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/docker/docker/client"
+)
+
+// ListContainerFilesHandler lists the directory named by the "path" query
+// parameter inside a container.
+func ListContainerFilesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		listing, err := listContainerDirectory(r.Context(), cli, r.PathValue("id"), dir)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, listing)
+	}
+}
+
+// DownloadContainerFileHandler downloads the file or directory named by the
+// "path" query parameter. Directories are sent as a tar.gz archive.
+func DownloadContainerFileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		download, err := openContainerDownload(r.Context(), cli, r.PathValue("id"), p)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer download.Close()
+
+		if err := download.Stream(w); err != nil {
+			log.Printf("Error streaming %s from container %s: %v", p, r.PathValue("id"), err)
+		}
+	}
+}
+
+// UploadContainerFilesHandler copies the files sent in the multipart "file"
+// field into the directory named by the "path" query parameter.
+func UploadContainerFilesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		form, err := parseUploadForm(w, r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer form.RemoveAll()
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		uploaded, err := uploadToContainer(r.Context(), cli, r.PathValue("id"), dir, form.File["file"])
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, uploaded)
+	}
+}
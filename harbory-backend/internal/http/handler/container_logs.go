@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/net/websocket"
+)
+
+// maxLogLineBytes bounds how much of a single unterminated line is buffered
+// before it is sent anyway.
+const maxLogLineBytes = 64 * 1024
+
+type LogMessage struct {
+	Type      string `json:"type"` // "stdout", "stderr", "error", "end"
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// ContainerLogsHandler streams a container's stdout and stderr. Clients that
+// ask for a WebSocket upgrade receive one JSON LogMessage per frame; everyone
+// else gets the same messages as Server-Sent Events named after their type.
+func ContainerLogsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := logsOptionsFromQuery(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		containerID := r.PathValue("id")
+		info, err := cli.ContainerInspect(r.Context(), containerID)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		tty := info.Config != nil && info.Config.Tty
+
+		if stream.IsWebSocketRequest(r) {
+			websocket.Handler(func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go cancelOnWSClose(ws, cancel)
+				go stream.KeepAliveWebSocket(ctx, ws)
+
+				send := func(msg LogMessage) error {
+					return websocket.JSON.Send(ws, msg)
+				}
+				streamContainerLogs(ctx, cli, info.ID, tty, options, send)
+			}).ServeHTTP(w, r)
+			return
+		}
+
+		sse, err := stream.NewSSEWriter(w)
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer sse.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go sse.KeepAlive(ctx)
+
+		send := func(msg LogMessage) error {
+			return sse.Send(msg.Type, msg)
+		}
+		streamContainerLogs(ctx, cli, info.ID, tty, options, send)
+	}
+}
+
+// streamContainerLogs copies the container's log stream to send, one message
+// per line, and finishes with an "end" or "error" message. Containers started
+// with a TTY have no separate stderr, so their output is reported as stdout.
+func streamContainerLogs(ctx context.Context, cli *client.Client, containerID string, tty bool, options container.LogsOptions, send func(LogMessage) error) {
+	logs, err := cli.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		_ = send(LogMessage{Type: "error", Message: err.Error()})
+		return
+	}
+	defer logs.Close()
+
+	stdout := &logLineWriter{stream: "stdout", timestamps: options.Timestamps, send: send}
+	stderr := &logLineWriter{stream: "stderr", timestamps: options.Timestamps, send: send}
+
+	if tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+	if flushErr := stdout.Flush(); flushErr != nil {
+		return
+	}
+	if flushErr := stderr.Flush(); flushErr != nil {
+		return
+	}
+	if err != nil {
+		_ = send(LogMessage{Type: "error", Message: err.Error()})
+		return
+	}
+	_ = send(LogMessage{Type: "end", Message: "Log stream ended"})
+}
+
+// logLineWriter turns the byte stream of one log source into LogMessages,
+// one per line, splitting off the RFC 3339 prefix Docker adds when
+// timestamps are requested.
+type logLineWriter struct {
+	stream     string
+	timestamps bool
+	send       func(LogMessage) error
+	buf        []byte
+}
+
+func (lw *logLineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := lw.buf[:i]
+		lw.buf = lw.buf[i+1:]
+		if err := lw.emit(line); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(lw.buf) >= maxLogLineBytes {
+		if err := lw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush sends any buffered partial line.
+func (lw *logLineWriter) Flush() error {
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	line := lw.buf
+	lw.buf = nil
+	return lw.emit(line)
+}
+
+func (lw *logLineWriter) emit(line []byte) error {
+	msg := LogMessage{
+		Type:    lw.stream,
+		Message: strings.TrimSuffix(string(line), "\r"),
+	}
+
+	if lw.timestamps {
+		if ts, rest, ok := strings.Cut(msg.Message, " "); ok {
+			msg.Timestamp = ts
+			msg.Message = rest
+		}
+	}
+
+	return lw.send(msg)
+}
+
+// logsOptionsFromQuery builds LogsOptions from the follow, tail, since,
+// until, timestamps, stdout and stderr query parameters.
+func logsOptionsFromQuery(r *http.Request) (container.LogsOptions, error) {
+	query := r.URL.Query()
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Since:      query.Get("since"),
+		Until:      query.Get("until"),
+		Tail:       query.Get("tail"),
+	}
+
+	var err error
+	if options.Follow, err = queryBool(r, "follow"); err != nil {
+		return options, err
+	}
+	if options.Timestamps, err = queryBool(r, "timestamps"); err != nil {
+		return options, err
+	}
+	if query.Get("stdout") != "" {
+		if options.ShowStdout, err = queryBool(r, "stdout"); err != nil {
+			return options, err
+		}
+	}
+	if query.Get("stderr") != "" {
+		if options.ShowStderr, err = queryBool(r, "stderr"); err != nil {
+			return options, err
+		}
+	}
+	if !options.ShowStdout && !options.ShowStderr {
+		return options, invalidParameter(fmt.Errorf("at least one of stdout or stderr must be enabled"))
+	}
+
+	if options.Tail == "" {
+		options.Tail = "all"
+	}
+	if options.Tail != "all" {
+		if n, err := strconv.Atoi(options.Tail); err != nil || n < 0 {
+			return options, invalidParameter(fmt.Errorf("invalid tail %q: must be \"all\" or a non-negative number", options.Tail))
+		}
+	}
+
+	return options, nil
+}
+
+// cancelOnWSClose reads and discards client frames until the connection is
+// closed, then calls cancel. Hijacked connections are not tied to the request
+// context, so this is how WebSocket streams notice a client going away.
+func cancelOnWSClose(ws *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+
+	var discard string
+	for {
+		if err := websocket.Message.Receive(ws, &discard); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	// recreateTimeout bounds the whole stop/create/start/rollback sequence and
+	// replaces the server-wide WriteTimeout for this endpoint.
+	recreateTimeout = 2 * time.Minute
+
+	// recreateStartGracePeriod is how long a replacement container must stay
+	// up before the old one is removed.
+	recreateStartGracePeriod = 2 * time.Second
+)
+
+// ContainerPatch lists the settings that can be changed when a container is
+// recreated. Fields that are omitted keep their current value.
+type ContainerPatch struct {
+	Env           map[string]*string `json:"env,omitempty"`    // a null value removes the variable
+	Ports         *[]PortBindingSpec `json:"ports,omitempty"`  // replaces all published ports
+	Mounts        *[]MountSpec       `json:"mounts,omitempty"` // replaces all explicit mounts
+	Labels        map[string]*string `json:"labels,omitempty"` // a null value removes the label
+	RestartPolicy *RestartPolicySpec `json:"restart_policy,omitempty"`
+	Resources     *ResourceSpec      `json:"resources,omitempty"` // replaces all CPU and memory limits
+}
+
+type RecreateContainerResponse struct {
+	ID         string   `json:"id"`
+	PreviousID string   `json:"previous_id"`
+	Name       string   `json:"name"`
+	Started    bool     `json:"started"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+func (p ContainerPatch) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	errs = append(errs, validateEnvKeys("env", sortedKeys(p.Env))...)
+	if p.Ports != nil {
+		errs = append(errs, validatePorts("ports", *p.Ports)...)
+	}
+	if p.Mounts != nil {
+		errs = append(errs, validateMounts("mounts", *p.Mounts)...)
+	}
+	if _, ok := p.Labels[""]; ok {
+		errs = append(errs, response.FieldError{Field: "labels", Message: "label keys must not be empty"})
+	}
+	if p.RestartPolicy != nil {
+		errs = append(errs, p.RestartPolicy.validate("restart_policy")...)
+	}
+	if p.Resources != nil {
+		errs = append(errs, p.Resources.validate("resources")...)
+	}
+
+	return errs
+}
+
+// RecreateContainerHandler applies a ContainerPatch to an existing container
+// by replacing it with a new one under the same name, keeping its volumes and
+// networks. If the replacement cannot be created or does not stay running,
+// it is removed and the original container is put back as it was.
+func RecreateContainerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch ContainerPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if fieldErrs := patch.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(recreateTimeout))
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// A client that disconnects half way must not leave the container
+		// renamed or stopped, so the sequence does not inherit cancellation.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), recreateTimeout)
+		defer cancel()
+
+		info, err := cli.ContainerInspect(ctx, r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		config, hostConfig, networkingConfig := recreateConfig(info)
+		preserved := implicitVolumeMounts(info)
+		patch.applyTo(config, hostConfig)
+		hostConfig.Mounts = append(hostConfig.Mounts, uncoveredMounts(preserved, hostConfig)...)
+
+		resp, err := recreateContainer(ctx, cli, info, config, hostConfig, networkingConfig)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// recreateConfig copies the create-time configuration of an inspected
+// container, dropping the values Docker generates for each new container.
+func recreateConfig(info container.InspectResponse) (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+	config := *info.Config
+	config.Env = slices.Clone(info.Config.Env)
+	config.Labels = maps.Clone(info.Config.Labels)
+	config.ExposedPorts = maps.Clone(info.Config.ExposedPorts)
+
+	shortID := info.ID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+	if config.Hostname == shortID {
+		config.Hostname = ""
+	}
+
+	hostConfig := *info.HostConfig
+	hostConfig.Binds = slices.Clone(info.HostConfig.Binds)
+	hostConfig.Mounts = slices.Clone(info.HostConfig.Mounts)
+	hostConfig.PortBindings = maps.Clone(info.HostConfig.PortBindings)
+
+	networkingConfig := &network.NetworkingConfig{}
+	if info.NetworkSettings != nil && len(info.NetworkSettings.Networks) > 0 {
+		networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{}
+		for name, endpoint := range info.NetworkSettings.Networks {
+			aliases := slices.DeleteFunc(slices.Clone(endpoint.Aliases), func(alias string) bool {
+				return alias == shortID
+			})
+			networkingConfig.EndpointsConfig[name] = &network.EndpointSettings{
+				IPAMConfig: endpoint.IPAMConfig,
+				Links:      endpoint.Links,
+				Aliases:    aliases,
+				DriverOpts: endpoint.DriverOpts,
+				GwPriority: endpoint.GwPriority,
+			}
+		}
+	}
+
+	return &config, &hostConfig, networkingConfig
+}
+
+func (p ContainerPatch) applyTo(config *container.Config, hostConfig *container.HostConfig) {
+	for _, key := range sortedKeys(p.Env) {
+		value := p.Env[key]
+		i := slices.IndexFunc(config.Env, func(entry string) bool {
+			name, _, _ := strings.Cut(entry, "=")
+			return name == key
+		})
+		switch {
+		case value == nil && i >= 0:
+			config.Env = slices.Delete(config.Env, i, i+1)
+		case value != nil && i >= 0:
+			config.Env[i] = key + "=" + *value
+		case value != nil:
+			config.Env = append(config.Env, key+"="+*value)
+		}
+	}
+
+	if len(p.Labels) > 0 && config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for key, value := range p.Labels {
+		if value == nil {
+			delete(config.Labels, key)
+		} else {
+			config.Labels[key] = *value
+		}
+	}
+
+	if p.Ports != nil {
+		exposed, bindings := portsToDocker(*p.Ports)
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = nat.PortSet{}
+		}
+		maps.Copy(config.ExposedPorts, exposed)
+		hostConfig.PortBindings = bindings
+	}
+
+	if p.Mounts != nil {
+		hostConfig.Binds = nil
+		hostConfig.Mounts = mountsToDocker(*p.Mounts)
+	}
+
+	if p.RestartPolicy != nil {
+		hostConfig.RestartPolicy = p.RestartPolicy.toDocker()
+	}
+
+	if p.Resources != nil {
+		p.Resources.applyTo(&hostConfig.Resources)
+	}
+}
+
+// implicitVolumeMounts returns the volumes the container has that are not
+// part of its HostConfig, such as those created for VOLUME instructions in
+// the image. Without carrying them over, the replacement would get fresh,
+// empty anonymous volumes.
+func implicitVolumeMounts(info container.InspectResponse) []mount.Mount {
+	var mounts []mount.Mount
+	for _, m := range info.Mounts {
+		if m.Type != mount.TypeVolume || mountTargetCovered(m.Destination, info.HostConfig) {
+			continue
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   m.Name,
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+		})
+	}
+	return mounts
+}
+
+func uncoveredMounts(mounts []mount.Mount, hostConfig *container.HostConfig) []mount.Mount {
+	var uncovered []mount.Mount
+	for _, m := range mounts {
+		if !mountTargetCovered(m.Target, hostConfig) {
+			uncovered = append(uncovered, m)
+		}
+	}
+	return uncovered
+}
+
+func mountTargetCovered(target string, hostConfig *container.HostConfig) bool {
+	for _, bind := range hostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) >= 2 && parts[1] == target {
+			return true
+		}
+	}
+	for _, m := range hostConfig.Mounts {
+		if m.Target == target {
+			return true
+		}
+	}
+	_, ok := hostConfig.Tmpfs[target]
+	return ok
+}
+
+// recreateContainer swaps the inspected container for one built from the new
+// configuration. The original is stopped and renamed aside rather than
+// removed, so it can be restored if anything goes wrong.
+func recreateContainer(ctx context.Context, cli *client.Client, info container.InspectResponse, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (RecreateContainerResponse, error) {
+	name := strings.TrimPrefix(info.Name, "/")
+	wasRunning := info.State != nil && info.State.Running
+	backupName := fmt.Sprintf("%s-harbory-old-%d", name, time.Now().Unix())
+
+	resp := RecreateContainerResponse{
+		PreviousID: info.ID,
+		Name:       name,
+	}
+
+	if wasRunning {
+		if err := cli.ContainerStop(ctx, info.ID, container.StopOptions{}); err != nil {
+			return resp, fmt.Errorf("failed to stop container: %w", err)
+		}
+	}
+
+	restore := func(cause error) error {
+		errs := []error{cause}
+		if err := cli.ContainerRename(ctx, info.ID, name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore original name: %w", err))
+		}
+		if wasRunning {
+			if err := cli.ContainerStart(ctx, info.ID, container.StartOptions{}); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restart original container: %w", err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	if err := cli.ContainerRename(ctx, info.ID, backupName); err != nil {
+		if wasRunning {
+			_ = cli.ContainerStart(ctx, info.ID, container.StartOptions{})
+		}
+		return resp, fmt.Errorf("failed to rename container: %w", err)
+	}
+
+	created, err := cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return resp, restore(fmt.Errorf("failed to create replacement container: %w", err))
+	}
+	resp.ID = created.ID
+	resp.Warnings = created.Warnings
+
+	if wasRunning {
+		if err := startAndVerify(ctx, cli, created.ID); err != nil {
+			_ = cli.ContainerRemove(ctx, created.ID, container.RemoveOptions{Force: true})
+			return resp, restore(err)
+		}
+		resp.Started = true
+	}
+
+	if err := cli.ContainerRemove(ctx, info.ID, container.RemoveOptions{Force: true}); err != nil {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("previous container %s could not be removed: %v", backupName, err))
+	}
+
+	return resp, nil
+}
+
+// startAndVerify starts a container and checks that it is still running
+// after recreateStartGracePeriod, catching containers that exit immediately.
+func startAndVerify(ctx context.Context, cli *client.Client, containerID string) error {
+	if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start replacement container: %w", err)
+	}
+
+	select {
+	case <-time.After(recreateStartGracePeriod):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	info, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect replacement container: %w", err)
+	}
+	if info.State == nil || !info.State.Running || info.State.Restarting {
+		exitCode := 0
+		if info.State != nil {
+			exitCode = info.State.ExitCode
+		}
+		return fmt.Errorf("replacement container did not stay running (exit code %d)", exitCode)
+	}
+
+	return nil
+}
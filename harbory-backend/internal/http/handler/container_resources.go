@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// CPU CFS period bounds enforced by the kernel, in microseconds.
+const (
+	minCPUPeriod = 1000
+	maxCPUPeriod = 1000000
+)
+
+// ResourceUpdateRequest holds the limits that can be changed on a running
+// container. Omitted fields are left as they are; Docker's update API cannot
+// reset a limit to zero, so "unlimited" is expressed as -1 where supported.
+type ResourceUpdateRequest struct {
+	CPUShares     *int64             `json:"cpu_shares,omitempty"`
+	CPUQuota      *int64             `json:"cpu_quota,omitempty"`  // microseconds per period, -1 for unlimited
+	CPUPeriod     *int64             `json:"cpu_period,omitempty"` // microseconds
+	Memory        *int64             `json:"memory,omitempty"`     // bytes
+	MemorySwap    *int64             `json:"memory_swap,omitempty"`
+	PidsLimit     *int64             `json:"pids_limit,omitempty"` // -1 for unlimited
+	RestartPolicy *RestartPolicySpec `json:"restart_policy,omitempty"`
+}
+
+type ResourceUpdateResponse struct {
+	ID       string                `json:"id"`
+	Warnings []string              `json:"warnings,omitempty"`
+	Before   *container.HostConfig `json:"before"`
+	After    *container.HostConfig `json:"after"`
+}
+
+// Validate checks the request against the container's current HostConfig, so
+// combinations Docker would reject (such as a swap limit below the memory
+// limit that is already in place) are reported per field up front.
+func (req ResourceUpdateRequest) Validate(current *container.HostConfig) []response.FieldError {
+	var errs []response.FieldError
+	add := func(field, message string) {
+		errs = append(errs, response.FieldError{Field: field, Message: message})
+	}
+
+	if req.CPUShares != nil && (*req.CPUShares < 2 || *req.CPUShares > 262144) {
+		add("cpu_shares", "must be between 2 and 262144")
+	}
+	if req.CPUQuota != nil && *req.CPUQuota != -1 && *req.CPUQuota < 1000 {
+		add("cpu_quota", "must be -1 or at least 1000 microseconds")
+	}
+	if req.CPUPeriod != nil && (*req.CPUPeriod < minCPUPeriod || *req.CPUPeriod > maxCPUPeriod) {
+		add("cpu_period", fmt.Sprintf("must be between %d and %d microseconds", minCPUPeriod, maxCPUPeriod))
+	}
+	if (req.CPUQuota != nil || req.CPUPeriod != nil) && current.NanoCPUs > 0 {
+		add("cpu_quota", "cannot be combined with the container's existing cpus limit; recreate the container instead")
+	}
+
+	memory := current.Memory
+	if req.Memory != nil {
+		if *req.Memory < minMemoryLimit {
+			add("memory", fmt.Sprintf("must be at least %d bytes", minMemoryLimit))
+		}
+		memory = *req.Memory
+	}
+	if req.MemorySwap != nil && *req.MemorySwap != -1 {
+		if memory == 0 {
+			add("memory_swap", "requires a memory limit")
+		} else if *req.MemorySwap < memory {
+			add("memory_swap", "must be -1 or at least the memory limit")
+		}
+	}
+	if req.Memory != nil && req.MemorySwap == nil && current.MemorySwap > 0 && *req.Memory > current.MemorySwap {
+		add("memory", "exceeds the current memory_swap limit; raise memory_swap in the same request")
+	}
+
+	// The daemon reads 0 as "leave unchanged", which is what omitting it means.
+	if req.PidsLimit != nil && (*req.PidsLimit < -1 || *req.PidsLimit == 0) {
+		add("pids_limit", "must be -1 (unlimited) or a positive number")
+	}
+
+	if req.RestartPolicy != nil {
+		errs = append(errs, req.RestartPolicy.validate("restart_policy")...)
+		if current.AutoRemove && req.RestartPolicy.Name != string(container.RestartPolicyDisabled) {
+			add("restart_policy.name", "must be \"no\" for containers started with auto-remove")
+		}
+	}
+
+	return errs
+}
+
+func (req ResourceUpdateRequest) isEmpty() bool {
+	return req.CPUShares == nil && req.CPUQuota == nil && req.CPUPeriod == nil && req.Memory == nil &&
+		req.MemorySwap == nil && req.PidsLimit == nil && req.RestartPolicy == nil
+}
+
+func (req ResourceUpdateRequest) toDocker() container.UpdateConfig {
+	var update container.UpdateConfig
+
+	if req.CPUShares != nil {
+		update.CPUShares = *req.CPUShares
+	}
+	if req.CPUQuota != nil {
+		update.CPUQuota = *req.CPUQuota
+	}
+	if req.CPUPeriod != nil {
+		update.CPUPeriod = *req.CPUPeriod
+	}
+	if req.Memory != nil {
+		update.Memory = *req.Memory
+	}
+	if req.MemorySwap != nil {
+		update.MemorySwap = *req.MemorySwap
+	}
+	update.PidsLimit = req.PidsLimit
+	if req.RestartPolicy != nil {
+		update.RestartPolicy = req.RestartPolicy.toDocker()
+	}
+
+	return update
+}
+
+// UpdateContainerResourcesHandler changes resource limits and the restart
+// policy of a container in place and returns its HostConfig from before and
+// after the update.
+func UpdateContainerResourcesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResourceUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.isEmpty() {
+			response.SendError(w, http.StatusBadRequest, "At least one resource field must be set")
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		before, err := cli.ContainerInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if fieldErrs := req.Validate(before.HostConfig); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		updated, err := cli.ContainerUpdate(r.Context(), before.ID, req.toDocker())
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		after, err := cli.ContainerInspect(r.Context(), before.ID)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, ResourceUpdateResponse{
+			ID:       before.ID,
+			Warnings: updated.Warnings,
+			Before:   before.HostConfig,
+			After:    after.HostConfig,
+		})
+	}
+}
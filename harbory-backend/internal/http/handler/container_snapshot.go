@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+type FilesystemChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // "added", "changed" or "deleted"
+}
+
+type ContainerChangesResponse struct {
+	Changes []FilesystemChange `json:"changes"`
+	Added   int                `json:"added"`
+	Changed int                `json:"changed"`
+	Deleted int                `json:"deleted"`
+}
+
+type CommitContainerRequest struct {
+	Repository string   `json:"repository"`
+	Tag        string   `json:"tag,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Author     string   `json:"author,omitempty"`
+	Changes    []string `json:"changes,omitempty"` // Dockerfile instructions, e.g. "ENV DEBUG=1"
+	Pause      *bool    `json:"pause,omitempty"`   // defaults to true
+}
+
+type CommitContainerResponse struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+}
+
+// GetContainerChangesHandler returns the files added, changed or deleted in a
+// container's writable layer compared to its image.
+func GetContainerChangesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		changes, err := cli.ContainerDiff(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := ContainerChangesResponse{Changes: make([]FilesystemChange, 0, len(changes))}
+		for _, change := range changes {
+			var kind string
+			switch change.Kind {
+			case container.ChangeAdd:
+				kind = "added"
+				resp.Added++
+			case container.ChangeDelete:
+				kind = "deleted"
+				resp.Deleted++
+			default:
+				kind = "changed"
+				resp.Changed++
+			}
+			resp.Changes = append(resp.Changes, FilesystemChange{Path: change.Path, Kind: kind})
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// CommitContainerHandler snapshots a container into a new image. The
+// container is paused while committing unless the request sets pause=false.
+func CommitContainerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CommitContainerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		ref, fieldErrs := repositoryTagReference(req.Repository, req.Tag)
+		if len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// Committing a large writable layer can outlast the server's
+		// WriteTimeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		pause := true
+		if req.Pause != nil {
+			pause = *req.Pause
+		}
+
+		committed, err := cli.ContainerCommit(r.Context(), r.PathValue("id"), container.CommitOptions{
+			Reference: ref,
+			Comment:   req.Message,
+			Author:    req.Author,
+			Changes:   req.Changes,
+			Pause:     pause,
+		})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, CommitContainerResponse{
+			ID:        committed.ID,
+			Reference: ref,
+		})
+	}
+}
+
+// repositoryTagReference validates a repository name and tag, given
+// separately, and joins them into an image reference. The tag defaults to
+// "latest".
+func repositoryTagReference(repository, tag string) (string, []response.FieldError) {
+	if repository == "" {
+		return "", []response.FieldError{{Field: "repository", Message: "is required"}}
+	}
+
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return "", []response.FieldError{{Field: "repository", Message: "is not a valid repository name: " + err.Error()}}
+	}
+	if !reference.IsNameOnly(named) {
+		return "", []response.FieldError{{Field: "repository", Message: "must not include a tag or digest; use the tag field"}}
+	}
+
+	if tag == "" {
+		tag = "latest"
+	}
+	tagged, err := reference.WithTag(named, tag)
+	if err != nil {
+		return "", []response.FieldError{{Field: "tag", Message: "is not a valid tag"}}
+	}
+
+	return reference.FamiliarString(tagged), nil
+}
+
+// ExportContainerHandler streams a container's filesystem as a tar archive.
+func ExportContainerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ContainerInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		export, err := cli.ContainerExport(r.Context(), info.ID)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer export.Close()
+
+		_ = stream.DisableWriteDeadline(w)
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", attachmentDisposition(strings.TrimPrefix(info.Name, "/")+".tar"))
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := io.Copy(w, export); err != nil {
+			log.Printf("Error exporting container %s: %v", info.ID, err)
+		}
+	}
+}
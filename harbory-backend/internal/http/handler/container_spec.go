@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// minMemoryLimit is the smallest memory limit the Docker daemon accepts.
+const minMemoryLimit = 6 * 1024 * 1024
+
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// ContainerSpec is the API description of a container, translated into the
+// Docker create configuration by toDockerConfig.
+type ContainerSpec struct {
+	Name          string             `json:"name,omitempty"`
+	Image         string             `json:"image"`
+	Command       []string           `json:"command,omitempty"`
+	Entrypoint    []string           `json:"entrypoint,omitempty"`
+	WorkingDir    string             `json:"working_dir,omitempty"`
+	User          string             `json:"user,omitempty"`
+	Env           map[string]string  `json:"env,omitempty"`
+	Ports         []PortBindingSpec  `json:"ports,omitempty"`
+	Mounts        []MountSpec        `json:"mounts,omitempty"`
+	Networks      []string           `json:"networks,omitempty"`
+	Labels        map[string]string  `json:"labels,omitempty"`
+	RestartPolicy *RestartPolicySpec `json:"restart_policy,omitempty"`
+	Resources     *ResourceSpec      `json:"resources,omitempty"`
+}
+
+type PortBindingSpec struct {
+	ContainerPort int    `json:"container_port"`
+	HostPort      int    `json:"host_port,omitempty"` // 0 lets Docker pick a free port
+	HostIP        string `json:"host_ip,omitempty"`
+	Protocol      string `json:"protocol,omitempty"` // "tcp" (default), "udp" or "sctp"
+}
+
+type MountSpec struct {
+	Type     string `json:"type"` // "volume", "bind" or "tmpfs"
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+type RestartPolicySpec struct {
+	Name              string `json:"name"` // "no", "always", "unless-stopped" or "on-failure"
+	MaximumRetryCount int    `json:"maximum_retry_count,omitempty"`
+}
+
+type ResourceSpec struct {
+	CPUs       float64 `json:"cpus,omitempty"`
+	CPUShares  int64   `json:"cpu_shares,omitempty"`
+	Memory     int64   `json:"memory,omitempty"`      // bytes
+	MemorySwap int64   `json:"memory_swap,omitempty"` // bytes of memory plus swap, -1 for unlimited
+}
+
+// Validate checks the spec and returns one FieldError per problem found, using
+// JSON paths such as "ports[0].container_port" to name the offending field.
+func (s ContainerSpec) Validate() []response.FieldError {
+	var errs []response.FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, response.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Image == "" {
+		add("image", "is required")
+	} else if _, err := reference.ParseNormalizedNamed(s.Image); err != nil {
+		add("image", "is not a valid image reference: %v", err)
+	}
+
+	if s.Name != "" && !containerNamePattern.MatchString(s.Name) {
+		add("name", "must match %s", containerNamePattern.String())
+	}
+
+	if s.WorkingDir != "" && !path.IsAbs(s.WorkingDir) {
+		add("working_dir", "must be an absolute path")
+	}
+
+	errs = append(errs, validateEnvKeys("env", sortedKeys(s.Env))...)
+	errs = append(errs, validatePorts("ports", s.Ports)...)
+	errs = append(errs, validateMounts("mounts", s.Mounts)...)
+
+	for i, name := range s.Networks {
+		if strings.TrimSpace(name) == "" {
+			add(fmt.Sprintf("networks[%d]", i), "must not be empty")
+		}
+	}
+
+	for key := range s.Labels {
+		if key == "" {
+			add("labels", "label keys must not be empty")
+		}
+	}
+
+	if s.RestartPolicy != nil {
+		errs = append(errs, s.RestartPolicy.validate("restart_policy")...)
+	}
+
+	if s.Resources != nil {
+		errs = append(errs, s.Resources.validate("resources")...)
+	}
+
+	return errs
+}
+
+func validateEnvKeys(field string, keys []string) []response.FieldError {
+	var errs []response.FieldError
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			errs = append(errs, response.FieldError{Field: field, Message: fmt.Sprintf("invalid variable name %q", key)})
+		}
+	}
+	return errs
+}
+
+func validatePorts(field string, ports []PortBindingSpec) []response.FieldError {
+	var errs []response.FieldError
+	add := func(field, message string) {
+		errs = append(errs, response.FieldError{Field: field, Message: message})
+	}
+
+	for i, port := range ports {
+		prefix := fmt.Sprintf("%s[%d]", field, i)
+		if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+			add(prefix+".container_port", "must be between 1 and 65535")
+		}
+		if port.HostPort < 0 || port.HostPort > 65535 {
+			add(prefix+".host_port", "must be between 0 and 65535")
+		}
+		if port.HostIP != "" && net.ParseIP(port.HostIP) == nil {
+			add(prefix+".host_ip", "is not a valid IP address")
+		}
+		switch port.Protocol {
+		case "", "tcp", "udp", "sctp":
+		default:
+			add(prefix+".protocol", "must be one of tcp, udp or sctp")
+		}
+	}
+
+	return errs
+}
+
+func validateMounts(field string, mounts []MountSpec) []response.FieldError {
+	var errs []response.FieldError
+	add := func(field, message string) {
+		errs = append(errs, response.FieldError{Field: field, Message: message})
+	}
+
+	for i, m := range mounts {
+		prefix := fmt.Sprintf("%s[%d]", field, i)
+		if !path.IsAbs(m.Target) {
+			add(prefix+".target", "must be an absolute path")
+		}
+		switch mount.Type(m.Type) {
+		case mount.TypeVolume:
+		case mount.TypeBind:
+			if !path.IsAbs(m.Source) {
+				add(prefix+".source", "must be an absolute host path for bind mounts")
+			}
+		case mount.TypeTmpfs:
+			if m.Source != "" {
+				add(prefix+".source", "must be empty for tmpfs mounts")
+			}
+		default:
+			add(prefix+".type", "must be one of volume, bind or tmpfs")
+		}
+	}
+
+	return errs
+}
+
+func (r ResourceSpec) validate(field string) []response.FieldError {
+	var errs []response.FieldError
+	add := func(field, message string) {
+		errs = append(errs, response.FieldError{Field: field, Message: message})
+	}
+
+	if r.CPUs < 0 {
+		add(field+".cpus", "must not be negative")
+	}
+	if r.CPUShares < 0 {
+		add(field+".cpu_shares", "must not be negative")
+	}
+	if r.Memory != 0 && r.Memory < minMemoryLimit {
+		add(field+".memory", fmt.Sprintf("must be at least %d bytes", minMemoryLimit))
+	}
+	if r.MemorySwap != 0 && r.MemorySwap != -1 {
+		if r.Memory == 0 {
+			add(field+".memory_swap", "requires memory to be set")
+		} else if r.MemorySwap < r.Memory {
+			add(field+".memory_swap", "must be -1 or at least the memory limit")
+		}
+	}
+
+	return errs
+}
+
+func (p RestartPolicySpec) validate(field string) []response.FieldError {
+	var errs []response.FieldError
+
+	switch container.RestartPolicyMode(p.Name) {
+	case container.RestartPolicyDisabled, container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		if p.MaximumRetryCount != 0 {
+			errs = append(errs, response.FieldError{Field: field + ".maximum_retry_count", Message: "is only allowed with the on-failure policy"})
+		}
+	case container.RestartPolicyOnFailure:
+		if p.MaximumRetryCount < 0 {
+			errs = append(errs, response.FieldError{Field: field + ".maximum_retry_count", Message: "must not be negative"})
+		}
+	default:
+		errs = append(errs, response.FieldError{Field: field + ".name", Message: "must be one of no, always, unless-stopped or on-failure"})
+	}
+
+	return errs
+}
+
+func (p RestartPolicySpec) toDocker() container.RestartPolicy {
+	return container.RestartPolicy{
+		Name:              container.RestartPolicyMode(p.Name),
+		MaximumRetryCount: p.MaximumRetryCount,
+	}
+}
+
+// toDockerConfig translates a validated spec into the three configuration
+// structs taken by ContainerCreate. The first network becomes the network
+// mode; the rest are attached as additional endpoints.
+func (s ContainerSpec) toDockerConfig() (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+	config := &container.Config{
+		Image:      s.Image,
+		Cmd:        s.Command,
+		Entrypoint: s.Entrypoint,
+		WorkingDir: s.WorkingDir,
+		User:       s.User,
+		Env:        envMapToList(s.Env),
+		Labels:     s.Labels,
+	}
+	hostConfig := &container.HostConfig{}
+	networkingConfig := &network.NetworkingConfig{}
+
+	config.ExposedPorts, hostConfig.PortBindings = portsToDocker(s.Ports)
+	hostConfig.Mounts = mountsToDocker(s.Mounts)
+
+	if len(s.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(s.Networks[0])
+		networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{}
+		for _, name := range s.Networks {
+			networkingConfig.EndpointsConfig[name] = &network.EndpointSettings{}
+		}
+	}
+
+	if s.RestartPolicy != nil {
+		hostConfig.RestartPolicy = s.RestartPolicy.toDocker()
+	}
+
+	if s.Resources != nil {
+		s.Resources.applyTo(&hostConfig.Resources)
+	}
+
+	return config, hostConfig, networkingConfig
+}
+
+// portsToDocker converts port bindings into the exposed port set and port map
+// used by the container and host configuration.
+func portsToDocker(ports []PortBindingSpec) (nat.PortSet, nat.PortMap) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+
+	for _, p := range ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		port := nat.Port(fmt.Sprintf("%d/%s", p.ContainerPort, protocol))
+		exposed[port] = struct{}{}
+
+		hostPort := ""
+		if p.HostPort != 0 {
+			hostPort = strconv.Itoa(p.HostPort)
+		}
+		bindings[port] = append(bindings[port], nat.PortBinding{
+			HostIP:   p.HostIP,
+			HostPort: hostPort,
+		})
+	}
+
+	return exposed, bindings
+}
+
+func mountsToDocker(mounts []MountSpec) []mount.Mount {
+	var converted []mount.Mount
+	for _, m := range mounts {
+		converted = append(converted, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return converted
+}
+
+func (r ResourceSpec) applyTo(resources *container.Resources) {
+	resources.NanoCPUs = int64(r.CPUs * 1e9)
+	resources.CPUShares = r.CPUShares
+	resources.Memory = r.Memory
+	resources.MemorySwap = r.MemorySwap
+}
+
+// envMapToList converts env into Docker's KEY=VALUE form, sorted by key so
+// the resulting configuration is stable.
+func envMapToList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for _, key := range sortedKeys(env) {
+		list = append(list, key+"="+env[key])
+	}
+	return list
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+type ContainerStats struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Read          time.Time `json:"read"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	OnlineCPUs    uint32    `json:"online_cpus"`
+	MemoryUsage   uint64    `json:"memory_usage_bytes"`
+	MemoryLimit   uint64    `json:"memory_limit_bytes"`
+	MemoryPercent float64   `json:"memory_percent"`
+	NetworkRx     uint64    `json:"network_rx_bytes"`
+	NetworkTx     uint64    `json:"network_tx_bytes"`
+	BlockRead     uint64    `json:"block_read_bytes"`
+	BlockWrite    uint64    `json:"block_write_bytes"`
+	PIDs          uint64    `json:"pids"`
+}
+
+type ContainerStatsMessage struct {
+	Type    string          `json:"type"` // "stats", "error", "end"
+	Stats   *ContainerStats `json:"stats,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// GetContainerStatsHandler returns a single stats sample for a container.
+// With stream=true, or over a WebSocket, it keeps sending a sample roughly
+// every second until the client disconnects or the container stops.
+func GetContainerStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streaming, err := queryBool(r, "stream")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		isWebSocket := stream.IsWebSocketRequest(r)
+		streaming = streaming || isWebSocket
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// Streams outlive the request context once a WebSocket is hijacked,
+		// so the stats request gets its own context, cancelled on disconnect.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		containerID := r.PathValue("id")
+		statsReader, err := cli.ContainerStats(ctx, containerID, streaming)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer statsReader.Body.Close()
+
+		decoder := json.NewDecoder(statsReader.Body)
+
+		if !streaming {
+			var raw container.StatsResponse
+			if err := decoder.Decode(&raw); err != nil {
+				errorResp := response.GeneralErrorResponse(err)
+				_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+				return
+			}
+			_ = response.WriteJSONResponse(w, http.StatusOK, calculateContainerStats(raw))
+			return
+		}
+
+		if isWebSocket {
+			websocket.Handler(func(ws *websocket.Conn) {
+				defer ws.Close()
+				go cancelOnWSClose(ws, cancel)
+
+				streamContainerStats(ctx, decoder, func(msg ContainerStatsMessage) error {
+					return websocket.JSON.Send(ws, msg)
+				})
+			}).ServeHTTP(w, r)
+			return
+		}
+
+		sse, err := stream.NewSSEWriter(w)
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer sse.Close()
+
+		stop := context.AfterFunc(r.Context(), cancel)
+		defer stop()
+		go sse.KeepAlive(ctx)
+
+		streamContainerStats(ctx, decoder, func(msg ContainerStatsMessage) error {
+			return sse.Send(msg.Type, msg)
+		})
+	}
+}
+
+// GetAllContainerStatsHandler returns one stats sample for every running
+// container. Samples are collected concurrently; containers that stop while
+// being sampled are left out.
+func GetAllContainerStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		ctx := r.Context()
+		containers, err := cli.ContainerList(ctx, container.ListOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		containers = withoutHelpers(containers)
+
+		results := make([]*ContainerStats, len(containers))
+		var wg sync.WaitGroup
+		for i, c := range containers {
+			wg.Add(1)
+			go func(i int, containerID string) {
+				defer wg.Done()
+
+				statsReader, err := cli.ContainerStats(ctx, containerID, false)
+				if err != nil {
+					return
+				}
+				defer statsReader.Body.Close()
+
+				var raw container.StatsResponse
+				if err := json.NewDecoder(statsReader.Body).Decode(&raw); err != nil {
+					return
+				}
+				stats := calculateContainerStats(raw)
+				results[i] = &stats
+			}(i, c.ID)
+		}
+		wg.Wait()
+
+		allStats := make([]ContainerStats, 0, len(results))
+		for _, stats := range results {
+			if stats != nil {
+				allStats = append(allStats, *stats)
+			}
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, allStats)
+	}
+}
+
+func streamContainerStats(ctx context.Context, decoder *json.Decoder, send func(ContainerStatsMessage) error) {
+	for {
+		var raw container.StatsResponse
+		if err := decoder.Decode(&raw); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				_ = send(ContainerStatsMessage{Type: "end", Message: "Stats stream ended"})
+				return
+			}
+			_ = send(ContainerStatsMessage{Type: "error", Message: err.Error()})
+			return
+		}
+
+		stats := calculateContainerStats(raw)
+		if err := send(ContainerStatsMessage{Type: "stats", Stats: &stats}); err != nil {
+			return
+		}
+	}
+}
+
+// calculateContainerStats derives the figures shown by `docker stats` from a
+// raw sample, using the same formulas as the Docker CLI.
+func calculateContainerStats(raw container.StatsResponse) ContainerStats {
+	stats := ContainerStats{
+		ID:          raw.ID,
+		Name:        strings.TrimPrefix(raw.Name, "/"),
+		Read:        raw.Read,
+		MemoryLimit: raw.MemoryStats.Limit,
+		PIDs:        raw.PidsStats.Current,
+	}
+
+	// CPU % is the container's share of the host CPU time that elapsed
+	// between the previous and current sample, scaled by the CPU count.
+	onlineCPUs := raw.CPUStats.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint32(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	stats.OnlineCPUs = onlineCPUs
+
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if systemDelta > 0 && cpuDelta > 0 {
+		stats.CPUPercent = (cpuDelta / systemDelta) * float64(onlineCPUs) * 100
+	}
+
+	// Memory usage excludes the page cache: "total_inactive_file" on
+	// cgroup v1 and "inactive_file" on cgroup v2.
+	stats.MemoryUsage = raw.MemoryStats.Usage
+	cache, ok := raw.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = raw.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	for _, network := range raw.Networks {
+		stats.NetworkRx += network.RxBytes
+		stats.NetworkTx += network.TxBytes
+	}
+
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// defaultPsArgs include the %CPU and %MEM columns, which the daemon's
+	// own default of "-ef" does not.
+	defaultPsArgs = "aux"
+
+	defaultTopInterval = 3 * time.Second
+	minTopInterval     = 1 * time.Second
+	maxTopInterval     = 60 * time.Second
+)
+
+var psArgsPattern = regexp.MustCompile(`^[a-zA-Z0-9,=%-]+$`)
+
+type ProcessInfo struct {
+	PID           string            `json:"pid"`
+	User          string            `json:"user,omitempty"`
+	CPUPercent    *float64          `json:"cpu_percent,omitempty"`
+	MemoryPercent *float64          `json:"memory_percent,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Fields        map[string]string `json:"fields"`
+}
+
+type ProcessList struct {
+	Titles    []string      `json:"titles"`
+	Processes []ProcessInfo `json:"processes"`
+	Time      time.Time     `json:"time"`
+}
+
+type ProcessListMessage struct {
+	Type      string       `json:"type"` // "processes", "error"
+	Processes *ProcessList `json:"processes,omitempty"`
+	Message   string       `json:"message,omitempty"`
+}
+
+// GetContainerTopHandler lists the processes running in a container using
+// the "ps_args" query parameter (default "aux"). With stream=true, or over a
+// WebSocket, the list is refreshed every "interval" seconds.
+func GetContainerTopHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		psArgs, err := psArgsFromQuery(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		streaming, err := queryBool(r, "stream")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		interval, err := topIntervalFromQuery(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		isWebSocket := stream.IsWebSocketRequest(r)
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		containerID := r.PathValue("id")
+		first, err := containerProcesses(r.Context(), cli, containerID, psArgs)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if !streaming && !isWebSocket {
+			_ = response.WriteJSONResponse(w, http.StatusOK, first)
+			return
+		}
+
+		if isWebSocket {
+			websocket.Handler(func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go cancelOnWSClose(ws, cancel)
+
+				streamContainerProcesses(ctx, cli, containerID, psArgs, interval, first, func(msg ProcessListMessage) error {
+					return websocket.JSON.Send(ws, msg)
+				})
+			}).ServeHTTP(w, r)
+			return
+		}
+
+		sse, err := stream.NewSSEWriter(w)
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer sse.Close()
+
+		go sse.KeepAlive(r.Context())
+
+		streamContainerProcesses(r.Context(), cli, containerID, psArgs, interval, first, func(msg ProcessListMessage) error {
+			return sse.Send(msg.Type, msg)
+		})
+	}
+}
+
+// streamContainerProcesses sends first, then a fresh process list every
+// interval until ctx is done, sending fails or the container stops.
+func streamContainerProcesses(ctx context.Context, cli *client.Client, containerID string, psArgs []string, interval time.Duration, first ProcessList, send func(ProcessListMessage) error) {
+	if err := send(ProcessListMessage{Type: "processes", Processes: &first}); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			list, err := containerProcesses(ctx, cli, containerID, psArgs)
+			if err != nil {
+				if ctx.Err() == nil {
+					_ = send(ProcessListMessage{Type: "error", Message: err.Error()})
+				}
+				return
+			}
+			if err := send(ProcessListMessage{Type: "processes", Processes: &list}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// containerProcesses runs ContainerTop and maps the well-known ps columns
+// onto ProcessInfo. Every column is also kept in Fields by its title.
+func containerProcesses(ctx context.Context, cli *client.Client, containerID string, psArgs []string) (ProcessList, error) {
+	top, err := cli.ContainerTop(ctx, containerID, psArgs)
+	if err != nil {
+		return ProcessList{}, err
+	}
+
+	list := ProcessList{
+		Titles:    top.Titles,
+		Processes: make([]ProcessInfo, 0, len(top.Processes)),
+		Time:      time.Now().UTC(),
+	}
+
+	for _, row := range top.Processes {
+		process := ProcessInfo{Fields: make(map[string]string, len(top.Titles))}
+		for i, title := range top.Titles {
+			if i >= len(row) {
+				break
+			}
+			value := row[i]
+			process.Fields[title] = value
+
+			switch strings.ToUpper(title) {
+			case "PID":
+				process.PID = value
+			case "USER", "UID":
+				process.User = value
+			// Only ps's %CPU is a percentage; the "C" column of ps -f is
+			// a scheduler figure and stays in Fields.
+			case "%CPU":
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					process.CPUPercent = &v
+				}
+			case "%MEM":
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					process.MemoryPercent = &v
+				}
+			case "COMMAND", "CMD", "ARGS":
+				process.Command = value
+			}
+		}
+		list.Processes = append(list.Processes, process)
+	}
+
+	return list, nil
+}
+
+// psArgsFromQuery splits the "ps_args" query parameter into arguments. Only
+// option-like words are accepted; ps runs on the host, so anything else is
+// refused.
+func psArgsFromQuery(r *http.Request) ([]string, error) {
+	raw := r.URL.Query().Get("ps_args")
+	if raw == "" {
+		raw = defaultPsArgs
+	}
+
+	args := strings.Fields(raw)
+	for _, arg := range args {
+		if !psArgsPattern.MatchString(arg) {
+			return nil, invalidParameter(fmt.Errorf("invalid ps_args %q", raw))
+		}
+	}
+
+	return args, nil
+}
+
+func topIntervalFromQuery(r *http.Request) (time.Duration, error) {
+	seconds, err := queryUint(r, "interval")
+	if err != nil {
+		return 0, err
+	}
+	if seconds == 0 {
+		return defaultTopInterval, nil
+	}
+
+	interval := time.Duration(seconds) * time.Second
+	if interval < minTopInterval || interval > maxTopInterval {
+		return 0, invalidParameter(fmt.Errorf("interval must be between %d and %d seconds", int(minTopInterval.Seconds()), int(maxTopInterval.Seconds())))
+	}
+
+	return interval, nil
+}
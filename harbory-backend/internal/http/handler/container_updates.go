@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/updates"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/client"
+)
+
+// GetContainerUpdatesHandler returns the latest update check of all running
+// containers. It does not contact any registry; see
+// CheckContainerUpdatesHandler.
+func GetContainerUpdatesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checker, ok := updateChecker(w)
+		if !ok {
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, checker.Report())
+	}
+}
+
+// GetContainerUpdateHandler returns the latest update check of one
+// container, with status "unchecked" if it has not been checked yet.
+func GetContainerUpdateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checker, ok := updateChecker(w)
+		if !ok {
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ContainerInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, checker.Status(info.ID))
+	}
+}
+
+// CheckContainerUpdatesHandler checks every running container against its
+// registry now and returns the new report. A check that is already running
+// is waited for first.
+func CheckContainerUpdatesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checker, ok := updateChecker(w)
+		if !ok {
+			return
+		}
+
+		// Each registry is asked at most once per image, but that can still
+		// take longer than the server's write timeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		report, err := checker.Check(r.Context())
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, report)
+	}
+}
+
+// updateChecker returns the update checker, answering 503 if the server was
+// started without one.
+func updateChecker(w http.ResponseWriter) (*updates.Checker, bool) {
+	checker := updates.GetChecker()
+	if checker == nil {
+		response.SendError(w, http.StatusServiceUnavailable, "Image update checker is not available")
+		return nil, false
+	}
+	return checker, true
+}
@@ -2,15 +2,52 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 )
 
+// containerListFields filters containers by their State ("running",
+// "exited", ...) and names without the leading slash. Newest come first.
+var containerListFields = listFields[container.Summary]{
+	ID:     func(c container.Summary) string { return c.ID },
+	Names:  containerNames,
+	Labels: func(c container.Summary) map[string]string { return c.Labels },
+	Status: func(c container.Summary) string { return string(c.State) },
+	Sorts: map[string]func(a, b container.Summary) int{
+		"name":    byString(func(c container.Summary) string { return strings.Join(containerNames(c), ",") }),
+		"image":   byString(func(c container.Summary) string { return c.Image }),
+		"status":  byString(func(c container.Summary) string { return string(c.State) }),
+		"created": byNumber(func(c container.Summary) int64 { return c.Created }),
+	},
+	DefaultSort: "created",
+	DefaultDesc: true,
+}
+
+func containerNames(c container.Summary) []string {
+	names := make([]string, 0, len(c.Names))
+	for _, name := range c.Names {
+		names = append(names, strings.TrimPrefix(name, "/"))
+	}
+	return names
+}
+
+// GetAllContainersHandler lists containers; see ListQuery for the supported
+// query parameters. Stopped containers are only included with all=true.
 func GetAllContainersHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseListQuery(r, containerListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
 		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
@@ -19,15 +56,15 @@ func GetAllContainersHandler() http.HandlerFunc {
 		}
 		defer cli.Close()
 
-		containers, err := cli.ContainerList(context.Background(), container.ListOptions{})
+		containers, err := cli.ContainerList(r.Context(), container.ListOptions{All: query.All})
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
+		containers = withoutHelpers(containers)
 
-		// Send all containers as JSON response
-		if err := response.WriteJSONResponse(w, http.StatusOK, containers); err != nil {
+		page := applyListQuery(containers, query, containerListFields)
+		if err := response.WriteJSONResponse(w, http.StatusOK, page); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
@@ -52,8 +89,7 @@ func GetContainerByParams() http.HandlerFunc {
 
 		container, _, err := cli.ContainerInspectWithRaw(context.Background(), containerID, true)
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
 
@@ -65,3 +101,176 @@ func GetContainerByParams() http.HandlerFunc {
 		}
 	}
 }
+
+type CreateContainerRequest struct {
+	ContainerSpec
+	Start bool `json:"start"`
+}
+
+type CreateContainerResponse struct {
+	ID       string   `json:"id"`
+	Started  bool     `json:"started"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// CreateContainerHandler creates a container from a ContainerSpec and starts
+// it when the request sets "start". A container that is created but fails to
+// start is left in place so its configuration and logs can be inspected.
+func CreateContainerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateContainerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		config, hostConfig, networkingConfig := req.toDockerConfig()
+		created, err := cli.ContainerCreate(r.Context(), config, hostConfig, networkingConfig, nil, req.Name)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := CreateContainerResponse{
+			ID:       created.ID,
+			Warnings: created.Warnings,
+		}
+
+		if req.Start {
+			if err := cli.ContainerStart(r.Context(), created.ID, container.StartOptions{}); err != nil {
+				_ = response.WriteDockerError(w, fmt.Errorf("container %s was created but failed to start: %w", created.ID, err))
+				return
+			}
+			resp.Started = true
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, resp)
+	}
+}
+
+type ContainerActionResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+func StartContainerHandler() http.HandlerFunc {
+	return containerActionHandler("start", func(r *http.Request, cli *client.Client, containerID string) error {
+		return cli.ContainerStart(r.Context(), containerID, container.StartOptions{})
+	})
+}
+
+func StopContainerHandler() http.HandlerFunc {
+	return containerActionHandler("stop", func(r *http.Request, cli *client.Client, containerID string) error {
+		options, err := stopOptionsFromQuery(r)
+		if err != nil {
+			return err
+		}
+		return cli.ContainerStop(r.Context(), containerID, options)
+	})
+}
+
+func RestartContainerHandler() http.HandlerFunc {
+	return containerActionHandler("restart", func(r *http.Request, cli *client.Client, containerID string) error {
+		options, err := stopOptionsFromQuery(r)
+		if err != nil {
+			return err
+		}
+		return cli.ContainerRestart(r.Context(), containerID, options)
+	})
+}
+
+func PauseContainerHandler() http.HandlerFunc {
+	return containerActionHandler("pause", func(r *http.Request, cli *client.Client, containerID string) error {
+		return cli.ContainerPause(r.Context(), containerID)
+	})
+}
+
+func UnpauseContainerHandler() http.HandlerFunc {
+	return containerActionHandler("unpause", func(r *http.Request, cli *client.Client, containerID string) error {
+		return cli.ContainerUnpause(r.Context(), containerID)
+	})
+}
+
+func KillContainerHandler() http.HandlerFunc {
+	return containerActionHandler("kill", func(r *http.Request, cli *client.Client, containerID string) error {
+		signal := r.URL.Query().Get("signal")
+		if signal == "" {
+			signal = "SIGKILL"
+		}
+		return cli.ContainerKill(r.Context(), containerID, signal)
+	})
+}
+
+func RemoveContainerHandler() http.HandlerFunc {
+	return containerActionHandler("remove", func(r *http.Request, cli *client.Client, containerID string) error {
+		force, err := queryBool(r, "force")
+		if err != nil {
+			return err
+		}
+		removeVolumes, err := queryBool(r, "volumes")
+		if err != nil {
+			return err
+		}
+		return cli.ContainerRemove(r.Context(), containerID, container.RemoveOptions{
+			Force:         force,
+			RemoveVolumes: removeVolumes,
+		})
+	})
+}
+
+// containerActionHandler wraps a single Docker call made against the container
+// named by the "id" path value, so every lifecycle endpoint shares the same
+// client setup, error mapping and response shape.
+func containerActionHandler(action string, fn func(r *http.Request, cli *client.Client, containerID string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		containerID := r.PathValue("id")
+		if err := fn(r, cli, containerID); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, ContainerActionResponse{
+			Status: response.StatusOK,
+			ID:     containerID,
+			Action: action,
+		})
+	}
+}
+
+// stopOptionsFromQuery reads the optional "timeout" (seconds) and "signal"
+// query parameters shared by the stop and restart endpoints.
+func stopOptionsFromQuery(r *http.Request) (container.StopOptions, error) {
+	options := container.StopOptions{Signal: r.URL.Query().Get("signal")}
+
+	if raw := r.URL.Query().Get("timeout"); raw != "" {
+		timeout, err := strconv.Atoi(raw)
+		if err != nil {
+			return options, invalidParameter(fmt.Errorf("invalid timeout %q: must be a number of seconds", raw))
+		}
+		options.Timeout = &timeout
+	}
+
+	return options, nil
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+// eventTypes are the Docker object types the events feed can be filtered by.
+var eventTypes = map[string]bool{
+	string(events.ContainerEventType): true,
+	string(events.ImageEventType):     true,
+	string(events.VolumeEventType):    true,
+	string(events.NetworkEventType):   true,
+}
+
+type DockerEvent struct {
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Scope      string            `json:"scope,omitempty"`
+	Time       int64             `json:"time"`
+	TimeNano   int64             `json:"time_nano"`
+}
+
+type EventStreamMessage struct {
+	Type    string       `json:"type"` // "event", "error", "end"
+	Event   *DockerEvent `json:"event,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// DockerEventsHandler relays the Docker events stream as Server-Sent Events or,
+// for upgrade requests, over a WebSocket. The "type", "action" and "label"
+// query parameters may be repeated to filter the feed. "since" replays past
+// events; SSE clients that reconnect with a Last-Event-ID header resume right
+// after the last event they received, whatever "since" says.
+func DockerEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := eventsOptionsFromRequest(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		if _, err := cli.Ping(r.Context()); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if stream.IsWebSocketRequest(r) {
+			websocket.Handler(func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go cancelOnWSClose(ws, cancel)
+				go stream.KeepAliveWebSocket(ctx, ws)
+
+				relayDockerEvents(ctx, cli, options, func(msg EventStreamMessage) error {
+					return websocket.JSON.Send(ws, msg)
+				})
+			}).ServeHTTP(w, r)
+			return
+		}
+
+		sse, err := stream.NewSSEWriter(w)
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer sse.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go sse.KeepAlive(ctx)
+
+		relayDockerEvents(ctx, cli, options, func(msg EventStreamMessage) error {
+			id := ""
+			if msg.Event != nil {
+				id = strconv.FormatInt(msg.Event.TimeNano, 10)
+			}
+			return sse.SendWithID(id, msg.Type, msg)
+		})
+	}
+}
+
+func relayDockerEvents(ctx context.Context, cli *client.Client, options events.ListOptions, send func(EventStreamMessage) error) {
+	messages, errs := cli.Events(ctx, options)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return
+			}
+			_ = send(EventStreamMessage{Type: "error", Message: err.Error()})
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				_ = send(EventStreamMessage{Type: "end", Message: "Event stream ended"})
+				return
+			}
+			event := DockerEvent{
+				Type:       string(msg.Type),
+				Action:     string(msg.Action),
+				ID:         msg.Actor.ID,
+				Attributes: msg.Actor.Attributes,
+				Scope:      msg.Scope,
+				Time:       msg.Time,
+				TimeNano:   msg.TimeNano,
+			}
+			if err := send(EventStreamMessage{Type: "event", Event: &event}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventsOptionsFromRequest builds the Docker events filters from the query
+// string. since and until accept anything `docker events` does: Unix
+// timestamps, RFC 3339 dates or durations such as "10m" relative to now.
+func eventsOptionsFromRequest(r *http.Request) (events.ListOptions, error) {
+	query := r.URL.Query()
+	args := filters.NewArgs()
+
+	for _, t := range query["type"] {
+		if !eventTypes[t] {
+			return events.ListOptions{}, invalidParameter(fmt.Errorf("invalid type %q: must be container, image, volume or network", t))
+		}
+		args.Add("type", t)
+	}
+	for _, action := range query["action"] {
+		args.Add("event", action)
+	}
+	for _, label := range query["label"] {
+		args.Add("label", label)
+	}
+
+	options := events.ListOptions{Filters: args}
+	now := time.Now()
+
+	// EventSource reconnects to the same URL, since included, so the
+	// Last-Event-ID header takes precedence: the client has already seen
+	// everything up to it.
+	since := query.Get("since")
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		nanos, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			return options, invalidParameter(fmt.Errorf("invalid Last-Event-ID %q", lastID))
+		}
+		// Resume one nanosecond later so the last delivered event is not
+		// sent twice.
+		nanos++
+		since = fmt.Sprintf("%d.%09d", nanos/int64(time.Second), nanos%int64(time.Second))
+	}
+	if since != "" {
+		ts, err := timetypes.GetTimestamp(since, now)
+		if err != nil {
+			return options, invalidParameter(fmt.Errorf("invalid since %q: %w", since, err))
+		}
+		options.Since = ts
+	}
+
+	if until := query.Get("until"); until != "" {
+		ts, err := timetypes.GetTimestamp(until, now)
+		if err != nil {
+			return options, invalidParameter(fmt.Errorf("invalid until %q: %w", until, err))
+		}
+		options.Until = ts
+	}
+
+	return options, nil
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// helperLabel marks the short-lived containers Harbory creates for its own
+// work, so they can be told apart from (and filtered out of) user containers.
+// internal/updates filters on the same label.
+const helperLabel = "io.harbory.helper"
+
+// withoutHelpers drops helper containers from a container listing. Docker
+// filters cannot exclude a label, so this is done after listing.
+func withoutHelpers(containers []container.Summary) []container.Summary {
+	return slices.DeleteFunc(containers, func(c container.Summary) bool {
+		_, ok := c.Labels[helperLabel]
+		return ok
+	})
+}
+
+// helperResult is the output of a helper container that ran to completion.
+type helperResult struct {
+	ExitCode int64
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// createHelperContainer creates a helper container for purpose, labelled
+// with helperLabel and without network access. Callers must remove it.
+func createHelperContainer(ctx context.Context, cli *client.Client, purpose string, config *container.Config, hostConfig *container.HostConfig) (string, error) {
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	config.Labels[helperLabel] = purpose
+	config.NetworkDisabled = true
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+	hostConfig.NetworkMode = "none"
+
+	created, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// removeHelperContainer removes a helper container. It uses its own context
+// so a cancelled request still cleans up after itself.
+func removeHelperContainer(cli *client.Client, id string) {
+	_ = cli.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true, RemoveVolumes: true})
+}
+
+// runHelperContainer creates a helper container, runs it to completion and
+// returns its output. The container is removed afterwards.
+func runHelperContainer(ctx context.Context, cli *client.Client, purpose string, config *container.Config, hostConfig *container.HostConfig) (helperResult, error) {
+	id, err := createHelperContainer(ctx, cli, purpose, config, hostConfig)
+	if err != nil {
+		return helperResult{}, err
+	}
+	defer removeHelperContainer(cli, id)
+
+	waitCh, errCh := cli.ContainerWait(ctx, id, container.WaitConditionNextExit)
+	if err := cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return helperResult{}, err
+	}
+
+	var result helperResult
+	select {
+	case status := <-waitCh:
+		if status.Error != nil {
+			return helperResult{}, fmt.Errorf("waiting for helper container: %s", status.Error.Message)
+		}
+		result.ExitCode = status.StatusCode
+	case err := <-errCh:
+		return helperResult{}, err
+	}
+
+	logs, err := cli.ContainerLogs(ctx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return helperResult{}, err
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return helperResult{}, err
+	}
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	return result, nil
+}
+
+// streamHelperContainer creates a helper container and runs it to
+// completion, feeding it stdin, if not nil, and copying its stdout to stdout
+// as it is produced, so neither has to fit in memory. The container is
+// removed afterwards. An error reading stdin is returned as is, ahead of the
+// failure it usually causes in the container.
+func streamHelperContainer(ctx context.Context, cli *client.Client, purpose string, config *container.Config, hostConfig *container.HostConfig, stdin io.Reader, stdout io.Writer) error {
+	config.AttachStdout = true
+	config.AttachStderr = true
+	if stdin != nil {
+		config.AttachStdin = true
+		config.OpenStdin = true
+		config.StdinOnce = true
+	}
+
+	id, err := createHelperContainer(ctx, cli, purpose, config, hostConfig)
+	if err != nil {
+		return err
+	}
+	defer removeHelperContainer(cli, id)
+
+	attach, err := cli.ContainerAttach(ctx, id, container.AttachOptions{
+		Stream: true,
+		Stdin:  stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+
+	waitCh, errCh := cli.ContainerWait(ctx, id, container.WaitConditionNextExit)
+	if err := cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return err
+	}
+
+	inputErr := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			input := &inputReader{r: stdin}
+			_, _ = io.Copy(attach.Conn, input)
+			_ = attach.CloseWrite()
+			inputErr <- input.err
+		}()
+	}
+
+	var stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(stdout, &stderr, attach.Reader); err != nil {
+		return err
+	}
+
+	result := helperResult{Stderr: stderr.Bytes()}
+	select {
+	case status := <-waitCh:
+		if status.Error != nil {
+			return fmt.Errorf("waiting for helper container: %s", status.Error.Message)
+		}
+		result.ExitCode = status.StatusCode
+	case err := <-errCh:
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		select {
+		case err := <-inputErr:
+			if err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	return result.err()
+}
+
+// inputReader records the first error reading a helper container's stdin,
+// as opposed to writing it to the container, which fails whenever the
+// container exits early.
+type inputReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// ensureHelperImage pulls ref, with any stored credentials for its registry,
+// unless the host already has it.
+func ensureHelperImage(ctx context.Context, cli *client.Client, ref string) error {
+	_, err := cli.ImageInspect(ctx, ref)
+	if err == nil || !cerrdefs.IsNotFound(err) {
+		return err
+	}
+
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return fmt.Errorf("invalid helper image %q: %w", ref, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	authHeader, err := registryAuthHeader(named, nil)
+	if err != nil {
+		return err
+	}
+	body, err := cli.ImagePull(ctx, named.String(), image.PullOptions{RegistryAuth: authHeader})
+	if err != nil {
+		return fmt.Errorf("pulling helper image %s: %w", ref, err)
+	}
+	defer body.Close()
+
+	if _, err := relayImageProgress(body, func(ImageProgressMessage) error { return nil }); err != nil {
+		return fmt.Errorf("pulling helper image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// err describes a helper container that exited with a non-zero code, using
+// what it wrote to stderr.
+func (r helperResult) err() error {
+	if r.ExitCode == 0 {
+		return nil
+	}
+	msg := strings.TrimSpace(string(r.Stderr))
+	if msg == "" {
+		msg = strings.TrimSpace(string(r.Stdout))
+	}
+	return fmt.Errorf("exit code %d: %s", r.ExitCode, msg)
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/client"
+)
+
+// ImportImagesResponse lists what an image archive contained: the tags it
+// restored, and the IDs of images that were saved without one.
+type ImportImagesResponse struct {
+	Images []string `json:"images"`
+	IDs    []string `json:"ids,omitempty"`
+}
+
+// ExportImagesHandler streams images as a `docker save` tar archive. Further
+// images can be added with repeated "image" query parameters, and "gzip=true"
+// compresses the archive. Images referenced by tag keep that tag when the
+// archive is loaded again; images referenced by ID are saved untagged.
+func ExportImagesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compress, err := queryBool(r, "gzip")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		names := append([]string{r.PathValue("id")}, r.URL.Query()["image"]...)
+		// The daemon only reports a missing image once the archive has
+		// started, so check them all while a 404 can still be sent.
+		for _, name := range names {
+			if _, err := cli.ImageInspect(r.Context(), name); err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		archive, err := cli.ImageSave(r.Context(), names)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer archive.Close()
+
+		filename := "images.tar"
+		if len(names) == 1 {
+			filename = imageArchiveName(names[0]) + ".tar"
+		}
+
+		_ = stream.DisableWriteDeadline(w)
+		if !compress {
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Header().Set("Content-Disposition", attachmentDisposition(filename))
+			w.WriteHeader(http.StatusOK)
+
+			if _, err := io.Copy(w, archive); err != nil {
+				log.Printf("Error exporting images %v: %v", names, err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", attachmentDisposition(filename+".gz"))
+		w.WriteHeader(http.StatusOK)
+
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, archive); err != nil {
+			log.Printf("Error exporting images %v: %v", names, err)
+			return
+		}
+		if err := gz.Close(); err != nil {
+			log.Printf("Error exporting images %v: %v", names, err)
+		}
+	}
+}
+
+// ImportImagesHandler loads images from a `docker save` archive, plain or
+// gzip-compressed. The archive is either the raw request body or the "file"
+// field of a multipart form, and is passed to the daemon as it arrives rather
+// than being buffered. Like PullImageHandler it streams progress to clients
+// that accept text/event-stream.
+func ImportImagesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		// Progress events are written while the upload is still being read.
+		_ = rc.EnableFullDuplex()
+
+		archive, err := uploadedArchive(r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		serveImageProgress(w, r, func(ctx context.Context, send func(ImageProgressMessage) error) (ImportImagesResponse, error) {
+			return loadImages(ctx, cli, archive, send)
+		}, ImportImagesResponse.progressMessage)
+	}
+}
+
+// uploadedArchive returns the uploaded archive: the first "file" part of a
+// multipart form, or the request body itself.
+func uploadedArchive(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, invalidParameter(fmt.Errorf("invalid upload: %w", err))
+	}
+	for {
+		part, err := form.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, invalidParameter(errors.New("no archive uploaded in the \"file\" field"))
+		}
+		if err != nil {
+			return nil, invalidParameter(fmt.Errorf("invalid upload: %w", err))
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// loadImages loads archive and collects the images the daemon reports as
+// loaded.
+func loadImages(ctx context.Context, cli *client.Client, archive io.Reader, send func(ImageProgressMessage) error) (ImportImagesResponse, error) {
+	load, err := cli.ImageLoad(ctx, archive, client.ImageLoadWithQuiet(false))
+	if err != nil {
+		return ImportImagesResponse{}, err
+	}
+	defer load.Body.Close()
+
+	result := ImportImagesResponse{Images: []string{}}
+	_, err = relayImageProgress(load.Body, func(msg ImageProgressMessage) error {
+		if ref, ok := strings.CutPrefix(msg.Message, "Loaded image: "); ok {
+			result.Images = append(result.Images, ref)
+		} else if id, ok := strings.CutPrefix(msg.Message, "Loaded image ID: "); ok {
+			result.IDs = append(result.IDs, id)
+		}
+		return send(msg)
+	})
+	if err != nil {
+		return ImportImagesResponse{}, err
+	}
+	if len(result.Images) == 0 && len(result.IDs) == 0 {
+		return ImportImagesResponse{}, invalidParameter(errors.New("the archive did not contain any images"))
+	}
+
+	return result, nil
+}
+
+// progressMessage is the "complete" message that ends a streamed import.
+func (p ImportImagesResponse) progressMessage() ImageProgressMessage {
+	msg := ImageProgressMessage{Type: "complete", Message: fmt.Sprintf("Loaded %d image(s)", len(p.Images)+len(p.IDs))}
+	if len(p.Images) > 0 {
+		msg.Image = p.Images[0]
+	}
+	if len(p.IDs) > 0 {
+		msg.ID = p.IDs[0]
+	}
+	return msg
+}
+
+// imageArchiveName turns an image reference into a file name, e.g.
+// "ghcr.io/org/app:1.0" into "app_1.0".
+func imageArchiveName(name string) string {
+	name = strings.TrimPrefix(name, "sha256:")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.NewReplacer(":", "_", "@", "_").Replace(name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if name == "" {
+		return "image"
+	}
+	return name
+}
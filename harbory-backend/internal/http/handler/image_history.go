@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/docker/docker/client"
+)
+
+// ImageLayer is one step of an image's history. Steps such as ENV or CMD
+// only change metadata and have EmptyLayer set.
+type ImageLayer struct {
+	ID          string    `json:"id"` // "<missing>" for steps built on another host
+	Instruction string    `json:"instruction"`
+	Command     string    `json:"command"`
+	CreatedBy   string    `json:"created_by"`
+	Created     time.Time `json:"created"`
+	Size        int64     `json:"size"`
+	Percent     float64   `json:"percent"`
+	EmptyLayer  bool      `json:"empty_layer"`
+	Tags        []string  `json:"tags,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+}
+
+// ImageHistoryResponse lists an image's layers newest first, like `docker
+// history`. Percent is each layer's share of Size, the sum of all layers.
+type ImageHistoryResponse struct {
+	ID     string       `json:"id"`
+	Size   int64        `json:"size"`
+	Layers []ImageLayer `json:"layers"`
+}
+
+// GetImageHistoryHandler returns the layers of an image with the instruction
+// that created each one and its share of the image's size.
+func GetImageHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ImageInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		history, err := cli.ImageHistory(r.Context(), info.ID)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := ImageHistoryResponse{ID: info.ID, Layers: make([]ImageLayer, 0, len(history))}
+		for _, item := range history {
+			resp.Size += item.Size
+		}
+
+		for _, item := range history {
+			instruction, command := parseCreatedBy(item.CreatedBy)
+			layer := ImageLayer{
+				ID:          item.ID,
+				Instruction: instruction,
+				Command:     command,
+				CreatedBy:   item.CreatedBy,
+				Created:     time.Unix(item.Created, 0).UTC(),
+				Size:        item.Size,
+				EmptyLayer:  item.Size == 0,
+				Tags:        item.Tags,
+				Comment:     item.Comment,
+			}
+			if resp.Size > 0 {
+				layer.Percent = float64(item.Size) / float64(resp.Size) * 100
+			}
+			resp.Layers = append(resp.Layers, layer)
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// parseCreatedBy turns a history entry's CreatedBy into the Dockerfile
+// instruction keyword and the rest of the line. The classic builder records
+// RUN steps as "/bin/sh -c cmd" and other steps as "/bin/sh -c #(nop) CMD
+// ..."; BuildKit records "RUN /bin/sh -c cmd # buildkit". RUN steps that
+// saw build arguments are prefixed with "|<count> NAME=value ...".
+func parseCreatedBy(createdBy string) (string, string) {
+	line := strings.TrimSpace(strings.TrimSuffix(createdBy, "# buildkit"))
+	line = stripBuildArgs(line)
+
+	if rest, ok := strings.CutPrefix(line, "/bin/sh -c #(nop)"); ok {
+		line = strings.TrimSpace(rest)
+	} else if rest, ok := strings.CutPrefix(line, "/bin/sh -c "); ok {
+		line = "RUN " + strings.TrimSpace(rest)
+	}
+
+	keyword, rest, _ := strings.Cut(line, " ")
+	if keyword == "" || strings.ToUpper(keyword) != keyword {
+		// Not a Dockerfile instruction, e.g. the base layer of an image
+		// created by `docker import` or `docker commit`.
+		return "", line
+	}
+
+	if keyword == "RUN" {
+		rest = strings.TrimPrefix(stripBuildArgs(rest), "/bin/sh -c ")
+	}
+	return keyword, strings.TrimSpace(rest)
+}
+
+func stripBuildArgs(line string) string {
+	if !strings.HasPrefix(line, "|") {
+		return line
+	}
+	if i := strings.Index(line, "/bin/sh -c "); i >= 0 {
+		return line[i:]
+	}
+	return line
+}
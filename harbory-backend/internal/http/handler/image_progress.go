@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+)
+
+// ImageProgressMessage is one structured event of an image pull, push or
+// load, or of a volume clone. Layer events carry the layer ID, a normalised
+// phase and byte counts; "status" events carry daemon messages that are not
+// about a single layer, and a clone's byte counts.
+type ImageProgressMessage struct {
+	Type    string  `json:"type"` // "layer", "status", "error", "complete"
+	Layer   string  `json:"layer,omitempty"`
+	Phase   string  `json:"phase,omitempty"`
+	Current int64   `json:"current,omitempty"` // bytes
+	Total   int64   `json:"total,omitempty"`   // bytes
+	Percent float64 `json:"percent,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Digest  string  `json:"digest,omitempty"`
+	Image   string  `json:"image,omitempty"`
+	ID      string  `json:"id,omitempty"`
+}
+
+// imageProgressPhases maps the daemon's human readable statuses onto stable
+// phase names clients can switch on.
+var imageProgressPhases = map[string]string{
+	"Pulling fs layer":     "pending",
+	"Waiting":              "waiting",
+	"Downloading":          "downloading",
+	"Verifying Checksum":   "verifying",
+	"Download complete":    "downloaded",
+	"Extracting":           "extracting",
+	"Pull complete":        "complete",
+	"Already exists":       "exists",
+	"Preparing":            "preparing",
+	"Pushing":              "pushing",
+	"Pushed":               "pushed",
+	"Layer already exists": "exists",
+	"Loading layer":        "loading",
+}
+
+// jsonProgressLine is a single line of the JSON stream the daemon writes for
+// pulls, pushes and loads.
+type jsonProgressLine struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	Stream         string `json:"stream"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux json.RawMessage `json:"aux"`
+}
+
+// imageProgressError is an error the daemon reported inside a progress
+// stream, after the response headers were already sent.
+type imageProgressError struct {
+	Code    int
+	Message string
+}
+
+func (e *imageProgressError) Error() string {
+	return e.Message
+}
+
+// HTTPStatus returns the status a non-streaming endpoint should answer with.
+// Registry authentication failures are reported as 403, like
+// response.DockerErrorStatus does, since a 401 would end the Harbory session.
+func (e *imageProgressError) HTTPStatus() int {
+	if e.Code == http.StatusUnauthorized || strings.Contains(e.Message, "denied") || strings.Contains(e.Message, "unauthorized") {
+		return http.StatusForbidden
+	}
+	if e.Code >= 400 && e.Code < 600 {
+		return e.Code
+	}
+	return http.StatusBadGateway
+}
+
+// relayImageProgress decodes the daemon's progress stream and passes each
+// line to send as an ImageProgressMessage. It returns the last digest the
+// daemon reported, or the error it reported in the stream. A failing send
+// does not stop the relay, so the operation itself is never cut short by a
+// slow or departed client.
+func relayImageProgress(body io.Reader, send func(ImageProgressMessage) error) (string, error) {
+	decoder := json.NewDecoder(body)
+	var digest string
+
+	for {
+		var line jsonProgressLine
+		if err := decoder.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return digest, nil
+			}
+			return digest, fmt.Errorf("reading progress: %w", err)
+		}
+
+		if line.Error != "" || line.ErrorDetail != nil {
+			progressErr := &imageProgressError{Message: line.Error}
+			if line.ErrorDetail != nil {
+				progressErr.Code = line.ErrorDetail.Code
+				if progressErr.Message == "" {
+					progressErr.Message = line.ErrorDetail.Message
+				}
+			}
+			return digest, progressErr
+		}
+
+		msg := ImageProgressMessage{Type: "status", Message: line.Status}
+		if d := progressDigest(line); d != "" {
+			digest = d
+			msg.Digest = d
+		}
+		if line.Stream != "" {
+			msg.Message = strings.TrimSpace(line.Stream)
+		}
+		if phase, ok := imageProgressPhase(line.Status); ok && line.ID != "" {
+			msg = ImageProgressMessage{
+				Type:    "layer",
+				Layer:   line.ID,
+				Phase:   phase,
+				Message: line.Status,
+				Current: line.ProgressDetail.Current,
+				Total:   line.ProgressDetail.Total,
+			}
+			if msg.Total > 0 {
+				msg.Percent = float64(msg.Current) / float64(msg.Total) * 100
+			}
+		} else if line.ID != "" && line.Status != "" {
+			msg.Message = line.ID + ": " + line.Status
+		}
+		if msg.Message == "" && msg.Type == "status" {
+			continue
+		}
+
+		_ = send(msg)
+	}
+}
+
+func imageProgressPhase(status string) (string, bool) {
+	if phase, ok := imageProgressPhases[status]; ok {
+		return phase, true
+	}
+	// "Mounted from library/alpine" during a cross-repository push.
+	if strings.HasPrefix(status, "Mounted from ") {
+		return "exists", true
+	}
+	return "", false
+}
+
+// progressDigest picks the image digest out of a pull's "Digest: sha256:..."
+// status or a push's aux record.
+func progressDigest(line jsonProgressLine) string {
+	if digest, ok := strings.CutPrefix(line.Status, "Digest: "); ok {
+		return digest
+	}
+	if len(line.Aux) > 0 {
+		var aux struct {
+			Digest string `json:"Digest"`
+		}
+		if json.Unmarshal(line.Aux, &aux) == nil {
+			return aux.Digest
+		}
+	}
+	return ""
+}
+
+// writeImageProgressError answers a non-streaming pull, push or load that
+// failed, whether the Docker SDK returned the error or the daemon reported it
+// inside the progress stream.
+func writeImageProgressError(w http.ResponseWriter, err error) {
+	var progressErr *imageProgressError
+	if errors.As(err, &progressErr) {
+		_ = response.WriteJSONResponse(w, progressErr.HTTPStatus(), response.GeneralErrorResponse(progressErr))
+		return
+	}
+	_ = response.WriteDockerError(w, err)
+}
+
+// imageOperation is a pull, push or load that reports progress through send.
+type imageOperation[T any] func(ctx context.Context, send func(ImageProgressMessage) error) (T, error)
+
+// serveImageProgress runs op for a POST endpoint. By default it answers with
+// op's result once op has finished. Clients that send "Accept:
+// text/event-stream" instead receive op's progress as it happens, ending
+// with the message complete builds from the result, or an "error" message.
+func serveImageProgress[T any](w http.ResponseWriter, r *http.Request, op imageOperation[T], complete func(T) ImageProgressMessage) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		_ = stream.DisableWriteDeadline(w)
+
+		result, err := op(r.Context(), func(ImageProgressMessage) error { return nil })
+		if err != nil {
+			writeImageProgressError(w, err)
+			return
+		}
+		_ = response.WriteJSONResponse(w, http.StatusOK, result)
+		return
+	}
+
+	sse, err := stream.NewSSEWriter(w)
+	if err != nil {
+		errorResp := response.GeneralErrorResponse(err)
+		_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+		return
+	}
+	defer sse.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go sse.KeepAlive(ctx)
+
+	streamImageProgress(ctx, op, complete, func(msg ImageProgressMessage) error {
+		return sse.Send(msg.Type, msg)
+	})
+}
+
+// streamImageProgress runs op, relaying its progress to send, and ends the
+// stream with the "complete" message built from its result or an "error"
+// message.
+func streamImageProgress[T any](ctx context.Context, op imageOperation[T], complete func(T) ImageProgressMessage, send func(ImageProgressMessage) error) {
+	result, err := op(ctx, send)
+	if err != nil {
+		if ctx.Err() == nil {
+			_ = send(ImageProgressMessage{Type: "error", Message: err.Error()})
+		}
+		return
+	}
+	_ = send(complete(result))
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+// platformPattern accepts os[/arch[/variant]], e.g. "linux/arm64/v8".
+var platformPattern = regexp.MustCompile(`^[a-z0-9_]+(/[a-z0-9_]+){0,2}$`)
+
+type PullImageRequest struct {
+	Image    string        `json:"image"`              // e.g. "nginx", "nginx:1.27" or "ghcr.io/org/app@sha256:..."
+	Platform string        `json:"platform,omitempty"` // e.g. "linux/arm64"
+	Auth     *RegistryAuth `json:"auth,omitempty"`     // falls back to stored credentials
+}
+
+type PullImageResponse struct {
+	Image   string `json:"image"`
+	ID      string `json:"id"`
+	Digest  string `json:"digest,omitempty"`
+	Message string `json:"message,omitempty"` // e.g. "Downloaded newer image for nginx:latest"
+}
+
+// reference validates the image reference, defaulting the tag to "latest".
+func (req PullImageRequest) reference() (reference.Named, []response.FieldError) {
+	var errs []response.FieldError
+
+	if req.Platform != "" && !platformPattern.MatchString(req.Platform) {
+		errs = append(errs, response.FieldError{Field: "platform", Message: "must look like os/arch, e.g. linux/amd64"})
+	}
+
+	if req.Image == "" {
+		return nil, append(errs, response.FieldError{Field: "image", Message: "is required"})
+	}
+	named, err := reference.ParseNormalizedNamed(req.Image)
+	if err != nil {
+		return nil, append(errs, response.FieldError{Field: "image", Message: "is not a valid image reference: " + err.Error()})
+	}
+
+	return reference.TagNameOnly(named), errs
+}
+
+// PullImageHandler pulls an image from its registry. It answers once the
+// pull has finished, unless the client sends "Accept: text/event-stream", in
+// which case ImageProgressMessage events are streamed while it runs.
+func PullImageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PullImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		named, fieldErrs := req.reference()
+		if len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		serveImageProgress(w, r, func(ctx context.Context, send func(ImageProgressMessage) error) (PullImageResponse, error) {
+			return pullImage(ctx, cli, named, req, send)
+		}, PullImageResponse.progressMessage)
+	}
+}
+
+// PullImageWebSocketHandler is the WebSocket variant of PullImageHandler. The
+// client sends a PullImageRequest as its first message and then receives
+// ImageProgressMessage events until a "complete" or "error" message.
+func PullImageWebSocketHandler() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		send := func(msg ImageProgressMessage) error {
+			return websocket.JSON.Send(ws, msg)
+		}
+
+		var req PullImageRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			_ = send(ImageProgressMessage{Type: "error", Message: "Invalid request: " + err.Error()})
+			return
+		}
+
+		named, fieldErrs := req.reference()
+		if len(fieldErrs) > 0 {
+			_ = send(ImageProgressMessage{Type: "error", Message: fieldErrorsMessage(fieldErrs)})
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			_ = send(ImageProgressMessage{Type: "error", Message: err.Error()})
+			return
+		}
+		defer cli.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go cancelOnWSClose(ws, cancel)
+
+		streamImageProgress(ctx, func(ctx context.Context, send func(ImageProgressMessage) error) (PullImageResponse, error) {
+			return pullImage(ctx, cli, named, req, send)
+		}, PullImageResponse.progressMessage, send)
+	})
+}
+
+// progressMessage is the "complete" message that ends a streamed pull.
+func (p PullImageResponse) progressMessage() ImageProgressMessage {
+	return ImageProgressMessage{
+		Type:    "complete",
+		Image:   p.Image,
+		ID:      p.ID,
+		Digest:  p.Digest,
+		Message: p.Message,
+	}
+}
+
+func pullImage(ctx context.Context, cli *client.Client, named reference.Named, req PullImageRequest, send func(ImageProgressMessage) error) (PullImageResponse, error) {
+	authHeader, err := registryAuthHeader(named, req.Auth)
+	if err != nil {
+		return PullImageResponse{}, err
+	}
+
+	body, err := cli.ImagePull(ctx, named.String(), image.PullOptions{
+		RegistryAuth: authHeader,
+		Platform:     req.Platform,
+	})
+	if err != nil {
+		return PullImageResponse{}, err
+	}
+	defer body.Close()
+
+	var summary string
+	digest, err := relayImageProgress(body, func(msg ImageProgressMessage) error {
+		if result, ok := strings.CutPrefix(msg.Message, "Status: "); ok {
+			summary = result
+		}
+		return send(msg)
+	})
+	if err != nil {
+		return PullImageResponse{}, err
+	}
+
+	info, err := cli.ImageInspect(ctx, named.String())
+	if err != nil {
+		return PullImageResponse{}, err
+	}
+
+	return PullImageResponse{
+		Image:   reference.FamiliarString(named),
+		ID:      info.ID,
+		Digest:  digest,
+		Message: summary,
+	}, nil
+}
+
+// fieldErrorsMessage flattens validation errors for streaming clients, which
+// only receive a single message.
+func fieldErrorsMessage(errs []response.FieldError) string {
+	parts := make([]string, 0, len(errs))
+	for _, e := range errs {
+		parts = append(parts, e.Field+" "+e.Message)
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+type PushImageRequest struct {
+	// Reference is where to push, e.g. "localhost:5000/team/app:1.0". The
+	// image is tagged with it first if needed. It may be omitted when the
+	// image has exactly one tag.
+	Reference string        `json:"reference,omitempty"`
+	Auth      *RegistryAuth `json:"auth,omitempty"` // falls back to stored credentials
+}
+
+type PushImageResponse struct {
+	Reference string `json:"reference"`
+	ID        string `json:"id"`
+	Digest    string `json:"digest,omitempty"`
+	Tagged    bool   `json:"tagged"` // the image was tagged with Reference for this push
+}
+
+// PushImageHandler pushes an image to a registry, using the stored
+// credentials for the registry unless the request carries its own. A tag
+// added for the push is removed again if the push fails. Like
+// PullImageHandler it streams progress to clients that accept
+// text/event-stream.
+func PushImageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PushImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ImageInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		named, fieldErrs := req.target(info)
+		if len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		ref := reference.FamiliarString(named)
+		tagged := !slices.Contains(info.RepoTags, ref)
+		if tagged {
+			if err := cli.ImageTag(r.Context(), info.ID, named.String()); err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		serveImageProgress(w, r, func(ctx context.Context, send func(ImageProgressMessage) error) (PushImageResponse, error) {
+			digest, err := pushImage(ctx, cli, named, req.Auth, send)
+			if err != nil {
+				// Take back the tag added for this push. On an image with no
+				// other reference that would delete the image, so it stays.
+				if tagged && len(info.RepoTags)+len(info.RepoDigests) > 0 {
+					_, _ = cli.ImageRemove(context.Background(), named.String(), image.RemoveOptions{})
+				}
+				return PushImageResponse{}, err
+			}
+			return PushImageResponse{Reference: ref, ID: info.ID, Digest: digest, Tagged: tagged}, nil
+		}, PushImageResponse.progressMessage)
+	}
+}
+
+// target returns the reference to push to: the requested one, or the
+// image's only tag.
+func (req PushImageRequest) target(info image.InspectResponse) (reference.Named, []response.FieldError) {
+	if req.Reference == "" {
+		if len(info.RepoTags) != 1 {
+			return nil, []response.FieldError{{Field: "reference", Message: "is required unless the image has exactly one tag"}}
+		}
+		req.Reference = info.RepoTags[0]
+	}
+
+	named, err := reference.ParseNormalizedNamed(req.Reference)
+	if err != nil {
+		return nil, []response.FieldError{{Field: "reference", Message: "is not a valid image reference: " + err.Error()}}
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, []response.FieldError{{Field: "reference", Message: "must not include a digest"}}
+	}
+
+	return reference.TagNameOnly(named), nil
+}
+
+// progressMessage is the "complete" message that ends a streamed push.
+func (p PushImageResponse) progressMessage() ImageProgressMessage {
+	return ImageProgressMessage{
+		Type:   "complete",
+		Image:  p.Reference,
+		ID:     p.ID,
+		Digest: p.Digest,
+	}
+}
+
+// pushImage pushes named and returns the manifest digest the registry
+// reported.
+func pushImage(ctx context.Context, cli *client.Client, named reference.Named, auth *RegistryAuth, send func(ImageProgressMessage) error) (string, error) {
+	authHeader, err := registryAuthHeader(named, auth)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := cli.ImagePush(ctx, named.String(), image.PushOptions{RegistryAuth: authHeader})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	return relayImageProgress(body, send)
+}
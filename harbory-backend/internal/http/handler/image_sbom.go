@@ -0,0 +1,543 @@
+package handler
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+const (
+	// maxSBOMFileBytes caps the package databases and lockfiles read into
+	// memory; larger ones are skipped with a warning.
+	maxSBOMFileBytes = 64 << 20
+
+	// maxGoBinaryBytes caps the executables checked for Go build info.
+	maxGoBinaryBytes = 512 << 20
+
+	// rpmQueryTimeout, rpmQueryMemory and rpmQueryPids bound the image's
+	// own rpm, which queryRPM runs.
+	rpmQueryTimeout = 2 * time.Minute
+	rpmQueryMemory  = 256 << 20
+	rpmQueryPids    = 64
+)
+
+// rpmDatabases are the files an rpm database may be kept in: sqlite on
+// current distributions, Berkeley DB or NDB on older and SUSE ones.
+var rpmDatabases = map[string]bool{
+	"/var/lib/rpm/rpmdb.sqlite":          true,
+	"/var/lib/rpm/Packages":              true,
+	"/var/lib/rpm/Packages.db":           true,
+	"/usr/lib/sysimage/rpm/rpmdb.sqlite": true,
+	"/usr/lib/sysimage/rpm/Packages.db":  true,
+}
+
+// rpmQueryFormat prints one tab separated line per installed package.
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}}:{}|\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`
+
+// sbomPackage is a package found in an image. Type is its package URL type.
+type sbomPackage struct {
+	Type     string // "apk", "deb", "rpm", "npm", "golang" or "pypi"
+	Name     string
+	Version  string
+	Epoch    string // rpm only
+	Arch     string
+	License  string
+	Location string // the file the package was read from
+}
+
+// imageOS is what the image's os-release file says about its distribution.
+type imageOS struct {
+	ID        string
+	VersionID string
+	Name      string
+}
+
+// sbomScan is the inventory of one image.
+type sbomScan struct {
+	Image    image.InspectResponse
+	OS       imageOS
+	Packages []sbomPackage
+	Warnings []string
+
+	osFromEtc bool
+	rpmdb     string
+	goBinary  *os.File
+}
+
+// GetImageSBOMHandler lists the packages installed in an image as a CycloneDX
+// 1.5 (format=cyclonedx, the default) or SPDX 2.3 (format=spdx) JSON
+// document. Packages are read from the apk, dpkg and rpm databases, from
+// package-lock.json, go.sum and requirements.txt files, and from the build
+// info of Go executables. Nothing is fetched from the network.
+func GetImageSBOMHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "":
+			format = "cyclonedx"
+		case "cyclonedx", "spdx":
+		default:
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("invalid format %q: must be cyclonedx or spdx", format)))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ImageInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		// Reading a large image's filesystem can take a while.
+		_ = stream.DisableWriteDeadline(w)
+
+		scan, err := scanImagePackages(r.Context(), cli, info)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if format == "spdx" {
+			_ = response.WriteJSONResponse(w, http.StatusOK, scan.spdx())
+			return
+		}
+		_ = response.WriteJSONResponse(w, http.StatusOK, scan.cycloneDX())
+	}
+}
+
+// scanImagePackages reads the image's filesystem through a container that
+// is created from it but never started.
+func scanImagePackages(ctx context.Context, cli *client.Client, info image.InspectResponse) (*sbomScan, error) {
+	// The command is never run; it only has to be set for images that have
+	// none, which the daemon would refuse to create a container from.
+	id, err := createHelperContainer(ctx, cli, "sbom", &container.Config{Image: info.ID, Cmd: []string{"sbom"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer removeHelperContainer(cli, id)
+
+	export, err := cli.ContainerExport(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer export.Close()
+
+	scan := &sbomScan{Image: info}
+	if err := scan.read(tar.NewReader(export)); err != nil {
+		return nil, fmt.Errorf("reading image filesystem: %w", err)
+	}
+
+	if scan.rpmdb != "" {
+		if err := scan.queryRPM(ctx, cli); err != nil {
+			scan.Warnings = append(scan.Warnings, fmt.Sprintf("%s was found but could not be read with the image's rpm: %v", scan.rpmdb, err))
+		}
+	}
+
+	scan.Packages = dedupeSBOMPackages(scan.Packages)
+	return scan, nil
+}
+
+func (s *sbomScan) read(tr *tar.Reader) error {
+	defer func() {
+		if s.goBinary != nil {
+			s.goBinary.Close()
+			os.Remove(s.goBinary.Name())
+		}
+	}()
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if strings.HasPrefix(name, "/proc/") || strings.HasPrefix(name, "/sys/") || strings.HasPrefix(name, "/dev/") {
+			continue
+		}
+
+		var parse func([]byte, string) []sbomPackage
+		base := path.Base(name)
+		switch {
+		case name == "/etc/os-release" || (name == "/usr/lib/os-release" && !s.osFromEtc):
+			data, ok := s.readFile(tr, hdr, name)
+			if ok {
+				s.OS = parseOSRelease(data)
+				s.osFromEtc = name == "/etc/os-release"
+			}
+			continue
+		case rpmDatabases[name]:
+			s.rpmdb = name
+			continue
+		case name == "/lib/apk/db/installed":
+			parse = parseApkInstalled
+		case name == "/var/lib/dpkg/status", path.Dir(name) == "/var/lib/dpkg/status.d" && !strings.Contains(base, "."):
+			parse = parseDpkgStatus
+		case strings.Contains(name, "/node_modules/"):
+			// Lockfiles shipped inside dependencies describe how they
+			// were developed, not what is installed.
+		case base == "package-lock.json":
+			parse = parseNpmLock
+		case base == "go.sum":
+			parse = parseGoSum
+		case base == "requirements.txt":
+			parse = parseRequirements
+		}
+
+		if parse != nil {
+			if data, ok := s.readFile(tr, hdr, name); ok {
+				s.Packages = append(s.Packages, parse(data, name)...)
+			}
+			continue
+		}
+
+		if hdr.Mode&0o111 != 0 && hdr.Size > 4 && hdr.Size <= maxGoBinaryBytes {
+			if err := s.readGoBinary(tr, name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *sbomScan) readFile(tr *tar.Reader, hdr *tar.Header, name string) ([]byte, bool) {
+	if hdr.Size > maxSBOMFileBytes {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("%s is larger than %d MiB and was skipped", name, maxSBOMFileBytes>>20))
+		return nil, false
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("%s could not be read: %v", name, err))
+		return nil, false
+	}
+	return data, true
+}
+
+// readGoBinary adds the modules recorded in an executable's Go build info.
+// debug/buildinfo needs random access, so ELF files are copied to a scratch
+// file first.
+func (s *sbomScan) readGoBinary(tr *tar.Reader, name string) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil || string(magic[:]) != "\x7fELF" {
+		return nil
+	}
+
+	if s.goBinary == nil {
+		f, err := os.CreateTemp("", "harbory-sbom-*")
+		if err != nil {
+			return err
+		}
+		s.goBinary = f
+	}
+	if err := s.goBinary.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.goBinary.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := s.goBinary.Write(magic[:]); err != nil {
+		return err
+	}
+	if _, err := io.Copy(s.goBinary, tr); err != nil {
+		return err
+	}
+
+	info, err := buildinfo.Read(s.goBinary)
+	if err != nil {
+		// Not a Go binary, or one built without module support.
+		return nil
+	}
+
+	s.Packages = append(s.Packages, sbomPackage{Type: "golang", Name: "stdlib", Version: info.GoVersion, Location: name})
+	if info.Main.Path != "" {
+		s.Packages = append(s.Packages, sbomPackage{Type: "golang", Name: info.Main.Path, Version: info.Main.Version, Location: name})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		s.Packages = append(s.Packages, sbomPackage{Type: "golang", Name: dep.Path, Version: dep.Version, Location: name})
+	}
+	return nil
+}
+
+// queryRPM lists rpm packages by running the image's own rpm, since the
+// database formats need rpm's libraries to read. That is code from the
+// image, so it runs without network access, capabilities or a writable root
+// filesystem, with memory and process limits, and is killed after
+// rpmQueryTimeout.
+func (s *sbomScan) queryRPM(ctx context.Context, cli *client.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, rpmQueryTimeout)
+	defer cancel()
+
+	pidsLimit := int64(rpmQueryPids)
+	result, err := runHelperContainer(ctx, cli, "sbom", &container.Config{
+		Image:      s.Image.ID,
+		User:       "0",
+		Entrypoint: []string{"rpm"},
+		Cmd:        []string{"-qa", "--qf", rpmQueryFormat},
+	}, &container.HostConfig{
+		CapDrop:        []string{"ALL"},
+		ReadonlyRootfs: true,
+		SecurityOpt:    []string{"no-new-privileges"},
+		Tmpfs:          map[string]string{"/tmp": "", "/var/tmp": ""},
+		Resources: container.Resources{
+			Memory:    rpmQueryMemory,
+			PidsLimit: &pidsLimit,
+		},
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("rpm did not finish within %s", rpmQueryTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	if err := result.err(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		s.Packages = append(s.Packages, sbomPackage{
+			Type:     "rpm",
+			Name:     fields[0],
+			Epoch:    fields[1],
+			Version:  fields[2],
+			Arch:     fields[3],
+			License:  fields[4],
+			Location: s.rpmdb,
+		})
+	}
+	return scanner.Err()
+}
+
+func parseOSRelease(data []byte) imageOS {
+	var release imageOS
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.ID = value
+		case "VERSION_ID":
+			release.VersionID = value
+		case "PRETTY_NAME":
+			release.Name = value
+		}
+	}
+	return release
+}
+
+// parseApkInstalled reads Alpine's package database: one block per package,
+// separated by blank lines, with single letter keys.
+func parseApkInstalled(data []byte, location string) []sbomPackage {
+	var packages []sbomPackage
+	pkg := sbomPackage{Type: "apk", Location: location}
+
+	for _, line := range strings.Split(string(data)+"\n", "\n") {
+		if line == "" {
+			if pkg.Name != "" {
+				packages = append(packages, pkg)
+			}
+			pkg = sbomPackage{Type: "apk", Location: location}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			pkg.Name = value
+		case "V":
+			pkg.Version = value
+		case "A":
+			pkg.Arch = value
+		case "L":
+			pkg.License = value
+		}
+	}
+	return packages
+}
+
+// parseDpkgStatus reads a dpkg status file. Distroless images have no status
+// file but one file per package in status.d, in the same format.
+func parseDpkgStatus(data []byte, location string) []sbomPackage {
+	var packages []sbomPackage
+
+	for _, paragraph := range strings.Split(string(data), "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(paragraph, "\n") {
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			if key, value, ok := strings.Cut(line, ":"); ok {
+				fields[key] = strings.TrimSpace(value)
+			}
+		}
+
+		if fields["Package"] == "" {
+			continue
+		}
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		packages = append(packages, sbomPackage{
+			Type:     "deb",
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Arch:     fields["Architecture"],
+			Location: location,
+		})
+	}
+	return packages
+}
+
+// npmLockDependency is an entry of a version 1 lockfile's dependency tree.
+type npmLockDependency struct {
+	Version      string                       `json:"version"`
+	Dependencies map[string]npmLockDependency `json:"dependencies"`
+}
+
+// parseNpmLock reads package-lock.json. Version 2 and 3 lockfiles list every
+// installed package under "packages", keyed by its node_modules path;
+// version 1 lockfiles only have the nested "dependencies" tree.
+func parseNpmLock(data []byte, location string) []sbomPackage {
+	var lock struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			License any    `json:"license"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]npmLockDependency `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil
+	}
+
+	var packages []sbomPackage
+	if len(lock.Packages) > 0 {
+		for key, entry := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || entry.Link {
+				continue
+			}
+			name := entry.Name
+			if name == "" {
+				name = key[i+len("node_modules/"):]
+			}
+			license, _ := entry.License.(string)
+			packages = append(packages, sbomPackage{Type: "npm", Name: name, Version: entry.Version, License: license, Location: location})
+		}
+		return packages
+	}
+
+	var walk func(map[string]npmLockDependency)
+	walk = func(deps map[string]npmLockDependency) {
+		for name, dep := range deps {
+			packages = append(packages, sbomPackage{Type: "npm", Name: name, Version: dep.Version, Location: location})
+			walk(dep.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return packages
+}
+
+// parseGoSum reads go.sum. Lines for a module's go.mod file only are skipped,
+// as the module's code may not be part of the build.
+func parseGoSum(data []byte, location string) []sbomPackage {
+	var packages []sbomPackage
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		packages = append(packages, sbomPackage{Type: "golang", Name: fields[0], Version: fields[1], Location: location})
+	}
+	return packages
+}
+
+var requirementName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*`)
+
+// parseRequirements reads a pip requirements file. Only "==" pins carry a
+// version; options, includes and URL requirements are skipped.
+func parseRequirements(data []byte, location string) []sbomPackage {
+	var packages []sbomPackage
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") || strings.Contains(line, "://") {
+			continue
+		}
+
+		name := requirementName.FindString(line)
+		if name == "" {
+			continue
+		}
+		pkg := sbomPackage{Type: "pypi", Name: name, Location: location}
+		if _, version, ok := strings.Cut(line, "=="); ok {
+			version = strings.TrimPrefix(version, "=")
+			pkg.Version, _, _ = strings.Cut(strings.TrimSpace(version), ",")
+			pkg.Version = strings.TrimSpace(pkg.Version)
+		}
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// dedupeSBOMPackages drops packages listed more than once, e.g. a module in
+// both a go.sum and the binary built from it, keeping the first location.
+func dedupeSBOMPackages(packages []sbomPackage) []sbomPackage {
+	slices.SortStableFunc(packages, func(a, b sbomPackage) int {
+		if c := strings.Compare(a.Type, b.Type); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Version, b.Version)
+	})
+	return slices.CompactFunc(packages, func(a, b sbomPackage) bool {
+		return a.Type == b.Type && a.Name == b.Name && a.Version == b.Version && a.Arch == b.Arch
+	})
+}
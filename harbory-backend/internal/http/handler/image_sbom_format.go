@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sbomToolName identifies Harbory as the author of the documents it writes.
+const sbomToolName = "harbory"
+
+// spdxLicenseID matches a single SPDX license identifier. Anything else the
+// package databases record, such as "GPL-2.0-or-later AND MIT" written with
+// plain spaces, is not a valid SPDX expression and is left out.
+var spdxLicenseID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+-]*$`)
+
+// pypiSeparators are treated alike in PyPI project names.
+var pypiSeparators = regexp.MustCompile(`[-_.]+`)
+
+type cycloneDXDocument struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp  string              `json:"timestamp"`
+	Tools      cycloneDXTools      `json:"tools"`
+	Component  cycloneDXComponent  `json:"component"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License struct {
+		ID   string `json:"id,omitempty"`
+		Name string `json:"name,omitempty"`
+	} `json:"license"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Comment           string             `json:"comment,omitempty"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// cycloneDX returns the scan as a CycloneDX 1.5 document with the image as
+// its subject.
+func (s *sbomScan) cycloneDX() cycloneDXDocument {
+	doc := cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: cycloneDXTools{Components: []cycloneDXComponent{
+				{Type: "application", Name: sbomToolName},
+			}},
+			Component: cycloneDXComponent{
+				Type:    "container",
+				BOMRef:  s.Image.ID,
+				Name:    s.imageName(),
+				Version: s.Image.ID,
+			},
+		},
+		Components: make([]cycloneDXComponent, 0, len(s.Packages)+1),
+	}
+	for _, warning := range s.Warnings {
+		doc.Metadata.Properties = append(doc.Metadata.Properties, cycloneDXProperty{Name: "harbory:warning", Value: warning})
+	}
+
+	if s.OS.ID != "" {
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:    "operating-system",
+			BOMRef:  "os:" + s.OS.ID + "@" + s.OS.VersionID,
+			Name:    s.OS.ID,
+			Version: s.OS.VersionID,
+		})
+	}
+
+	seen := make(map[string]bool, len(s.Packages))
+	for _, pkg := range s.Packages {
+		// bom-refs must be unique, and differently spelled PyPI names can
+		// share a package URL.
+		purl := pkg.purl(s.OS)
+		if seen[purl] {
+			continue
+		}
+		seen[purl] = true
+		component := cycloneDXComponent{
+			Type:       "library",
+			BOMRef:     purl,
+			Name:       pkg.Name,
+			Version:    pkg.Version,
+			PURL:       purl,
+			Properties: []cycloneDXProperty{{Name: "harbory:location", Value: pkg.Location}},
+		}
+		if pkg.License != "" {
+			var license cycloneDXLicense
+			if spdxLicenseID.MatchString(pkg.License) {
+				license.License.ID = pkg.License
+			} else {
+				license.License.Name = pkg.License
+			}
+			component.Licenses = []cycloneDXLicense{license}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+
+	return doc
+}
+
+// spdx returns the scan as an SPDX 2.3 document describing the image, which
+// contains every package found.
+func (s *sbomScan) spdx() spdxDocument {
+	name := s.imageName()
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://harbory.local/spdx/%s/%s", strings.TrimPrefix(s.Image.ID, "sha256:"), newUUID()),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + sbomToolName},
+		},
+		Comment:       strings.Join(s.Warnings, "\n"),
+		Packages:      make([]spdxPackage, 0, len(s.Packages)+1),
+		Relationships: make([]spdxRelationship, 0, len(s.Packages)+1),
+	}
+
+	doc.Packages = append(doc.Packages, spdxPackage{
+		Name:                  name,
+		SPDXID:                "SPDXRef-Image",
+		VersionInfo:           s.Image.ID,
+		DownloadLocation:      "NOASSERTION",
+		LicenseConcluded:      "NOASSERTION",
+		LicenseDeclared:       "NOASSERTION",
+		PrimaryPackagePurpose: "CONTAINER",
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      "SPDXRef-DOCUMENT",
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: "SPDXRef-Image",
+	})
+
+	for i, pkg := range s.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		declared := "NOASSERTION"
+		if spdxLicenseID.MatchString(pkg.License) {
+			declared = pkg.License
+		}
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           id,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  declared,
+			SourceInfo:       "found in " + pkg.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.purl(s.OS),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      "SPDXRef-Image",
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return doc
+}
+
+// imageName is the image's first tag, or its ID if it has none.
+func (s *sbomScan) imageName() string {
+	for _, tag := range s.Image.RepoTags {
+		if tag != "<none>:<none>" {
+			return tag
+		}
+	}
+	return s.Image.ID
+}
+
+// purl returns the package URL of pkg. Distribution packages are namespaced
+// by the image's distribution, as vulnerability databases expect.
+func (pkg sbomPackage) purl(release imageOS) string {
+	var segments []string
+	qualifiers := map[string]string{}
+
+	switch pkg.Type {
+	case "apk", "deb", "rpm":
+		namespace := release.ID
+		if namespace == "" {
+			namespace = map[string]string{"apk": "alpine", "deb": "debian", "rpm": "redhat"}[pkg.Type]
+		}
+		segments = []string{namespace, pkg.Name}
+		if pkg.Arch != "" {
+			qualifiers["arch"] = pkg.Arch
+		}
+		if release.ID != "" && release.VersionID != "" {
+			qualifiers["distro"] = release.ID + "-" + release.VersionID
+		}
+		if pkg.Epoch != "" {
+			qualifiers["epoch"] = pkg.Epoch
+		}
+	case "pypi":
+		// PyPI names are case insensitive.
+		name := strings.ToLower(pypiSeparators.ReplaceAllString(pkg.Name, "-"))
+		segments = []string{name}
+	default:
+		// npm scopes and Go module paths become the namespace.
+		segments = strings.Split(pkg.Name, "/")
+	}
+
+	for i, segment := range segments {
+		// "@" is not escaped in paths, but marks the version in a purl.
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "@", "%40")
+	}
+	purl := "pkg:" + pkg.Type + "/" + strings.Join(segments, "/")
+	if pkg.Version != "" {
+		purl += "@" + url.PathEscape(pkg.Version)
+	}
+
+	if len(qualifiers) > 0 {
+		keys := make([]string, 0, len(qualifiers))
+		for key := range qualifiers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key+"="+url.QueryEscape(qualifiers[key]))
+		}
+		purl += "?" + strings.Join(pairs, "&")
+	}
+	return purl
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// ImageContainer is a container, running or not, created from an image.
+type ImageContainer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// ImageUsage describes what an image is used for and what removing it would
+// free. UniqueSize leaves out layers shared with other images, which stay on
+// disk until the last image using them is removed.
+type ImageUsage struct {
+	Containers []ImageContainer `json:"containers"`
+	InUse      bool             `json:"in_use"`
+	Dangling   bool             `json:"dangling"`
+	// LastUsed is the latest time a container of the image started or
+	// stopped. It is unset when no container uses the image, since Docker
+	// keeps no record of removed containers.
+	LastUsed   *time.Time `json:"last_used,omitempty"`
+	UniqueSize int64      `json:"unique_size"`
+}
+
+// ImageListItem is an image as Docker lists it, with its usage.
+type ImageListItem struct {
+	image.Summary
+	Usage ImageUsage `json:"usage"`
+}
+
+// ImageReclaim is one entry of the cleanup ranking. Reclaimable is the
+// image's unique size when no container uses it, and 0 otherwise.
+type ImageReclaim struct {
+	ID          string     `json:"id"`
+	Tags        []string   `json:"tags"`
+	Created     time.Time  `json:"created"`
+	Size        int64      `json:"size"`
+	Reclaimable int64      `json:"reclaimable"`
+	Usage       ImageUsage `json:"usage"`
+}
+
+type ImageCleanupResponse struct {
+	Images      []ImageReclaim `json:"images"`
+	Reclaimable int64          `json:"reclaimable"`
+}
+
+// ImageCleanupRequest removes the listed images, or with All every image no
+// container uses. Cleaning up everything only reports what would be removed
+// unless DryRun is explicitly false. Images a container uses are only removed
+// with Force; their containers keep running from the untagged image.
+type ImageCleanupRequest struct {
+	IDs    []string `json:"ids,omitempty"`
+	All    bool     `json:"all,omitempty"`
+	Force  bool     `json:"force,omitempty"`
+	DryRun *bool    `json:"dry_run,omitempty"`
+}
+
+// GetImageCleanupHandler ranks images by the space removing them would
+// reclaim, largest first. Images a container uses reclaim nothing and are
+// only listed with include_used=true.
+func GetImageCleanupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		includeUsed, err := queryBool(r, "include_used")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		ranking, err := rankImageCleanup(r.Context(), cli)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := ImageCleanupResponse{Images: make([]ImageReclaim, 0, len(ranking))}
+		for _, item := range ranking {
+			if item.Usage.InUse && !includeUsed {
+				continue
+			}
+			resp.Images = append(resp.Images, item)
+			resp.Reclaimable += item.Reclaimable
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// CleanupImagesHandler removes images in the order of the cleanup ranking.
+// If any selected image is used by a container and force is not set, nothing
+// is removed and the request fails with 409 naming those containers.
+func CleanupImagesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ImageCleanupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+		dryRun := req.dryRun()
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		ranking, err := rankImageCleanup(r.Context(), cli)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		targets := make([]bulkTarget, 0, len(ranking))
+		usage := make(map[string]ImageUsage, len(ranking))
+		for _, item := range ranking {
+			if req.All && item.Usage.InUse {
+				continue
+			}
+			targets = append(targets, bulkTarget{ID: item.ID, Names: item.Tags})
+			usage[item.ID] = item.Usage
+		}
+
+		resp := BulkResponse{Action: "remove", DryRun: dryRun}
+		resp.Results = resolveBulkTargets(BulkRequest{IDs: req.IDs}, targets)
+
+		if !req.Force {
+			var refused []string
+			for _, result := range resp.Results {
+				if u, ok := usage[result.ID]; ok && u.InUse && result.Status == "" {
+					refused = append(refused, fmt.Sprintf("%s (used by %s)", imageDisplayName(result), imageContainerNames(u.Containers)))
+				}
+			}
+			if len(refused) > 0 {
+				err := fmt.Errorf("%w: images are in use: %s; set force to remove them anyway", cerrdefs.ErrConflict, strings.Join(refused, ", "))
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		_ = stream.DisableWriteDeadline(w)
+
+		// Images are removed one at a time: the daemon serialises image
+		// deletion anyway, and parents are only freed after their children.
+		for i := range resp.Results {
+			result := &resp.Results[i]
+			switch {
+			case result.Status != "":
+			case dryRun:
+				result.Status = "pending"
+			default:
+				_, err := cli.ImageRemove(r.Context(), result.ID, image.RemoveOptions{Force: req.Force, PruneChildren: true})
+				if err != nil {
+					result.Status = "error"
+					result.Error = err.Error()
+				} else {
+					result.Status = "ok"
+				}
+			}
+
+			switch result.Status {
+			case "ok":
+				resp.Succeeded++
+			case "error":
+				resp.Failed++
+			}
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+func (req ImageCleanupRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	switch {
+	case len(req.IDs) == 0 && !req.All:
+		errs = append(errs, response.FieldError{Field: "ids", Message: "is required; set all to clean up every unused image"})
+	case len(req.IDs) > 0 && req.All:
+		errs = append(errs, response.FieldError{Field: "all", Message: "cannot be combined with ids"})
+	}
+
+	return errs
+}
+
+// dryRun reports whether the cleanup only reports what it would remove. It
+// defaults to true when cleaning up everything.
+func (req ImageCleanupRequest) dryRun() bool {
+	if req.DryRun != nil {
+		return *req.DryRun
+	}
+	return req.All
+}
+
+// rankImageCleanup returns all top-level images with their usage, ordered by
+// reclaimable space, then by unique size, then oldest first.
+func rankImageCleanup(ctx context.Context, cli *client.Client) ([]ImageReclaim, error) {
+	images, err := cli.ImageList(ctx, image.ListOptions{SharedSize: true})
+	if err != nil {
+		return nil, err
+	}
+	usages, err := imageUsages(ctx, cli, images)
+	if err != nil {
+		return nil, err
+	}
+
+	ranking := make([]ImageReclaim, 0, len(images))
+	for i, img := range images {
+		item := ImageReclaim{
+			ID:      img.ID,
+			Tags:    imageTags(img),
+			Created: time.Unix(img.Created, 0).UTC(),
+			Size:    img.Size,
+			Usage:   usages[i],
+		}
+		if !item.Usage.InUse {
+			item.Reclaimable = item.Usage.UniqueSize
+		}
+		ranking = append(ranking, item)
+	}
+
+	slices.SortFunc(ranking, func(a, b ImageReclaim) int {
+		if c := cmp.Compare(b.Reclaimable, a.Reclaimable); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Usage.UniqueSize, a.Usage.UniqueSize); c != 0 {
+			return c
+		}
+		return a.Created.Compare(b.Created)
+	})
+	return ranking, nil
+}
+
+// imageUsages returns the usage of each image, in the same order. images
+// must have been listed with SharedSize for UniqueSize to leave out shared
+// layers.
+func imageUsages(ctx context.Context, cli *client.Client, images []image.Summary) ([]ImageUsage, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	containers = withoutHelpers(containers)
+
+	byImage := make(map[string][]container.Summary)
+	for _, c := range containers {
+		byImage[c.ImageID] = append(byImage[c.ImageID], c)
+	}
+
+	var needed []string
+	for _, img := range images {
+		for _, c := range byImage[img.ID] {
+			needed = append(needed, c.ID)
+		}
+	}
+	lastUsed := containerLastUsed(ctx, cli, needed)
+
+	usages := make([]ImageUsage, len(images))
+	for i, img := range images {
+		u := ImageUsage{
+			Containers: []ImageContainer{},
+			Dangling:   len(imageTags(img)) == 0,
+			UniqueSize: imageUniqueSize(img),
+		}
+
+		for _, c := range byImage[img.ID] {
+			u.Containers = append(u.Containers, ImageContainer{
+				ID:    c.ID,
+				Name:  strings.Join(containerNames(c), ","),
+				State: c.State,
+			})
+			if t, ok := lastUsed[c.ID]; ok && (u.LastUsed == nil || t.After(*u.LastUsed)) {
+				u.LastUsed = &t
+			}
+		}
+		u.InUse = len(u.Containers) > 0
+		usages[i] = u
+	}
+
+	return usages, nil
+}
+
+// containerLastUsed inspects the given containers and returns, for each one
+// that has ever run, the later of its start and finish times. Containers
+// that disappear meanwhile are skipped.
+func containerLastUsed(ctx context.Context, cli *client.Client, ids []string) map[string]time.Time {
+	lastUsed := make(map[string]time.Time, len(ids))
+	var mu sync.Mutex
+	sem := make(chan struct{}, bulkConcurrency)
+	var wg sync.WaitGroup
+
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			info, err := cli.ContainerInspect(ctx, id)
+			if err != nil || info.State == nil {
+				return
+			}
+
+			var latest time.Time
+			for _, raw := range []string{info.State.StartedAt, info.State.FinishedAt} {
+				if t, err := time.Parse(time.RFC3339Nano, raw); err == nil && t.After(latest) {
+					latest = t
+				}
+			}
+			// Containers that never ran report the zero time.
+			if latest.Year() <= 1 {
+				return
+			}
+
+			mu.Lock()
+			lastUsed[id] = latest.UTC()
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return lastUsed
+}
+
+// imageUniqueSize is the size of the layers only img uses. SharedSize is -1
+// when the daemon was not asked to compute it.
+func imageUniqueSize(img image.Summary) int64 {
+	if img.SharedSize > 0 {
+		return img.Size - img.SharedSize
+	}
+	return img.Size
+}
+
+func imageDisplayName(result BulkResult) string {
+	if result.Name != "" {
+		return result.Name
+	}
+	id := strings.TrimPrefix(result.ID, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+func imageContainerNames(containers []ImageContainer) string {
+	names := make([]string, 0, len(containers))
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
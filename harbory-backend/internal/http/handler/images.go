@@ -2,15 +2,59 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/api/types/image"
 	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
 )
 
+// imageListFields filters images by status "dangling" (untagged) or
+// "tagged", and by any of their repository tags. Newest come first.
+var imageListFields = listFields[image.Summary]{
+	ID:     func(i image.Summary) string { return i.ID },
+	Names:  imageTags,
+	Labels: func(i image.Summary) map[string]string { return i.Labels },
+	Status: func(i image.Summary) string {
+		if len(imageTags(i)) == 0 {
+			return "dangling"
+		}
+		return "tagged"
+	},
+	Sorts: map[string]func(a, b image.Summary) int{
+		"name":        byString(func(i image.Summary) string { return strings.Join(imageTags(i), ",") }),
+		"size":        byNumber(func(i image.Summary) int64 { return i.Size }),
+		"unique_size": byNumber(imageUniqueSize),
+		"created":     byNumber(func(i image.Summary) int64 { return i.Created }),
+	},
+	DefaultSort: "created",
+	DefaultDesc: true,
+}
+
+// imageTags returns an image's repository tags without the "<none>:<none>"
+// placeholder Docker reports for untagged images.
+func imageTags(i image.Summary) []string {
+	tags := make([]string, 0, len(i.RepoTags))
+	for _, tag := range i.RepoTags {
+		if tag != "<none>:<none>" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// GetAllImagesHandler lists images with their usage; see ListQuery for the
+// supported query parameters. all=true includes intermediate images.
 func GetAllImagesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request){
+		query, err := parseListQuery(r, imageListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
 		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
@@ -19,14 +63,27 @@ func GetAllImagesHandler() http.HandlerFunc {
 		}
 		defer cli.Close()
 		
-		images, err := cli.ImageList(context.Background(), image.ListOptions{})
+		images, err := cli.ImageList(r.Context(), image.ListOptions{All: query.All, SharedSize: true})
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
 
-		if err := response.WriteJSONResponse(w, http.StatusOK, images); err != nil {
+		page := applyListQuery(images, query, imageListFields)
+		// Usage needs a container inspect per user of an image, so it is
+		// only worked out for the images on this page.
+		usages, err := imageUsages(r.Context(), cli, page.Items)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		items := make([]ImageListItem, 0, len(page.Items))
+		for i, img := range page.Items {
+			items = append(items, ImageListItem{Summary: img, Usage: usages[i]})
+		}
+
+		resp := ListPage[ImageListItem]{Items: items, Total: page.Total, Limit: page.Limit, NextCursor: page.NextCursor, Warnings: page.Warnings}
+		if err := response.WriteJSONResponse(w, http.StatusOK, resp); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
@@ -60,4 +117,104 @@ func GetImageByParams() http.HandlerFunc{
 		}
 
 	}
-}
\ No newline at end of file
+}
+type TagImageRequest struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"` // defaults to "latest"
+}
+
+type TagImageResponse struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+}
+
+type RemoveImageResponse struct {
+	Untagged []string `json:"untagged"`
+	Deleted  []string `json:"deleted"`
+}
+
+// TagImageHandler adds a repository:tag reference to an existing image.
+func TagImageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TagImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		ref, fieldErrs := repositoryTagReference(req.Repository, req.Tag)
+		if len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.ImageInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if err := cli.ImageTag(r.Context(), info.ID, ref); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, TagImageResponse{ID: info.ID, Reference: ref})
+	}
+}
+
+// RemoveImageHandler removes an image, or one of its tags when the "id" path
+// value is a reference and the image has others. force=true removes images
+// used by stopped containers and images with several tags; noprune=true keeps
+// untagged parent images.
+func RemoveImageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, err := queryBool(r, "force")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		noPrune, err := queryBool(r, "noprune")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		items, err := cli.ImageRemove(r.Context(), r.PathValue("id"), image.RemoveOptions{
+			Force:         force,
+			PruneChildren: !noPrune,
+		})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := RemoveImageResponse{Untagged: []string{}, Deleted: []string{}}
+		for _, item := range items {
+			if item.Untagged != "" {
+				resp.Untagged = append(resp.Untagged, item.Untagged)
+			}
+			if item.Deleted != "" {
+				resp.Deleted = append(resp.Deleted, item.Deleted)
+			}
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxListLimit = 1000
+
+// ListQuery is the filtering, sorting and pagination every GET /api/<resource>
+// list endpoint accepts:
+//
+//	all=true             include stopped containers and intermediate images
+//	label=key[=value]    repeatable; every label must match
+//	status=value         repeatable; matches any of the given values
+//	name=text            case-insensitive substring of the resource's name
+//	q=text               case-insensitive search across ID, names and labels
+//	sort=field&order=asc|desc
+//	limit=n&cursor=...   page size and the next_cursor of the previous page
+type ListQuery struct {
+	All    bool
+	Labels []string
+	Status []string
+	Name   string
+	Search string
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+// ListPage is the body returned by every list endpoint. Total counts the
+// items that matched the filters, before pagination.
+type ListPage[T any] struct {
+	Items      []T      `json:"items"`
+	Total      int      `json:"total"`
+	Limit      int      `json:"limit,omitempty"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// listFields describes how a resource type is filtered and sorted. Status is
+// nil for resources that have no status, in which case a status filter is
+// rejected rather than silently matching nothing.
+type listFields[T any] struct {
+	ID          func(T) string
+	Names       func(T) []string
+	Labels      func(T) map[string]string
+	Status      func(T) string
+	Sorts       map[string]func(a, b T) int
+	DefaultSort string
+	DefaultDesc bool
+}
+
+// parseListQuery reads a ListQuery from the query string, checking the sort
+// field and status filter against what the resource supports.
+func parseListQuery[T any](r *http.Request, fields listFields[T]) (ListQuery, error) {
+	query := r.URL.Query()
+
+	all, err := queryBool(r, "all")
+	if err != nil {
+		return ListQuery{}, err
+	}
+
+	q := ListQuery{
+		All:    all,
+		Labels: query["label"],
+		Status: query["status"],
+		Name:   strings.ToLower(query.Get("name")),
+		Search: strings.ToLower(query.Get("q")),
+		Sort:   query.Get("sort"),
+		Desc:   fields.DefaultDesc,
+	}
+
+	if len(q.Status) > 0 && fields.Status == nil {
+		return q, invalidParameter(fmt.Errorf("status filter is not supported for this resource"))
+	}
+
+	if q.Sort == "" {
+		q.Sort = fields.DefaultSort
+	} else if _, ok := fields.Sorts[q.Sort]; !ok {
+		return q, invalidParameter(fmt.Errorf("invalid sort %q: must be one of %s", q.Sort, strings.Join(sortedKeys(fields.Sorts), ", ")))
+	}
+
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return q, invalidParameter(fmt.Errorf("invalid order %q: must be asc or desc", order))
+	}
+
+	limit, err := queryUint(r, "limit")
+	if err != nil {
+		return q, err
+	}
+	if limit > maxListLimit {
+		return q, invalidParameter(fmt.Errorf("limit must not exceed %d", maxListLimit))
+	}
+	q.Limit = int(limit)
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		offset, err := decodeListCursor(cursor)
+		if err != nil {
+			return q, invalidParameter(fmt.Errorf("invalid cursor %q", cursor))
+		}
+		q.Offset = offset
+	}
+
+	return q, nil
+}
+
+// applyListQuery filters, sorts and pages items. Ties in the sort field are
+// broken by ID so that pages stay stable between requests.
+func applyListQuery[T any](items []T, q ListQuery, fields listFields[T]) ListPage[T] {
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		if listMatches(item, q, fields) {
+			matched = append(matched, item)
+		}
+	}
+
+	compare := fields.Sorts[q.Sort]
+	slices.SortStableFunc(matched, func(a, b T) int {
+		c := 0
+		if compare != nil {
+			c = compare(a, b)
+		}
+		if c == 0 {
+			c = strings.Compare(fields.ID(a), fields.ID(b))
+		}
+		if q.Desc {
+			return -c
+		}
+		return c
+	})
+
+	page := ListPage[T]{Total: len(matched), Limit: q.Limit}
+
+	start := min(q.Offset, len(matched))
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.NextCursor = encodeListCursor(end)
+	}
+	page.Items = matched[start:end]
+
+	return page
+}
+
+func listMatches[T any](item T, q ListQuery, fields listFields[T]) bool {
+	if len(q.Status) > 0 && !slices.Contains(q.Status, fields.Status(item)) {
+		return false
+	}
+
+	labels := fields.Labels(item)
+	if !labelsMatch(labels, q.Labels) {
+		return false
+	}
+
+	names := fields.Names(item)
+	if q.Name != "" && !slices.ContainsFunc(names, func(name string) bool {
+		return strings.Contains(strings.ToLower(name), q.Name)
+	}) {
+		return false
+	}
+
+	if q.Search != "" {
+		return listSearchMatches(q.Search, fields.ID(item), names, labels)
+	}
+
+	return true
+}
+
+// labelsMatch reports whether labels satisfy every selector, each either a
+// bare key that must be present or key=value.
+func labelsMatch(labels map[string]string, selectors []string) bool {
+	for _, selector := range selectors {
+		key, value, hasValue := strings.Cut(selector, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// listSearchMatches reports whether search occurs in the ID, any name, or any
+// label key or value. search is already lower-cased.
+func listSearchMatches(search, id string, names []string, labels map[string]string) bool {
+	if strings.Contains(strings.ToLower(id), search) {
+		return true
+	}
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), search) {
+			return true
+		}
+	}
+	for key, value := range labels {
+		if strings.Contains(strings.ToLower(key), search) || strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursors are opaque to clients; they carry the offset of the next page.
+func encodeListCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeListCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset")
+	}
+	return offset, nil
+}
+
+// byString and byNumber build sort comparators from a field accessor.
+func byString[T any](field func(T) string) func(a, b T) int {
+	return func(a, b T) int {
+		return strings.Compare(strings.ToLower(field(a)), strings.ToLower(field(b)))
+	}
+}
+
+func byNumber[T any, N cmp.Ordered](field func(T) N) func(a, b T) int {
+	return func(a, b T) int {
+		return cmp.Compare(field(a), field(b))
+	}
+}
@@ -2,15 +2,88 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 )
 
+// networkNamePattern matches the network names the daemon accepts.
+var networkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// CreateNetworkRequest creates a network. Subnet, Gateway and IPRange
+// configure its one IPAM pool; without a Subnet the daemon picks one.
+type CreateNetworkRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`   // defaults to "bridge"
+	Subnet     string            `json:"subnet,omitempty"`   // e.g. "172.28.0.0/16"
+	Gateway    string            `json:"gateway,omitempty"`  // e.g. "172.28.0.1"
+	IPRange    string            `json:"ip_range,omitempty"` // e.g. "172.28.5.0/24", within Subnet
+	Internal   bool              `json:"internal"`
+	Attachable bool              `json:"attachable"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// ConnectNetworkRequest connects a container to a network, optionally with
+// extra DNS aliases and a static address from the network's subnet.
+type ConnectNetworkRequest struct {
+	Container   string   `json:"container"`
+	Aliases     []string `json:"aliases,omitempty"`
+	IPv4Address string   `json:"ipv4_address,omitempty"`
+	IPv6Address string   `json:"ipv6_address,omitempty"`
+}
+
+type DisconnectNetworkRequest struct {
+	Container string `json:"container"`
+	Force     bool   `json:"force"`
+}
+
+// NetworkEndpointResponse is a container's place on a network after a
+// connect or disconnect. Endpoint is nil once disconnected.
+type NetworkEndpointResponse struct {
+	Network   string                    `json:"network"`
+	Container string                    `json:"container"`
+	Endpoint  *network.EndpointSettings `json:"endpoint,omitempty"`
+}
+
+type RemoveNetworkResponse struct {
+	Name                   string   `json:"name"`
+	DisconnectedContainers []string `json:"disconnected_containers"`
+}
+
+// networkListFields sorts networks by name unless asked otherwise. Networks
+// have no status to filter on.
+var networkListFields = listFields[network.Summary]{
+	ID:     func(n network.Summary) string { return n.ID },
+	Names:  func(n network.Summary) []string { return []string{n.Name} },
+	Labels: func(n network.Summary) map[string]string { return n.Labels },
+	Sorts: map[string]func(a, b network.Summary) int{
+		"name":    byString(func(n network.Summary) string { return n.Name }),
+		"driver":  byString(func(n network.Summary) string { return n.Driver }),
+		"created": func(a, b network.Summary) int { return a.Created.Compare(b.Created) },
+	},
+	DefaultSort: "name",
+}
+
+// GetAllNetworksHandler lists networks; see ListQuery for the supported query
+// parameters.
 func GetAllNetworksHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseListQuery(r, networkListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
 		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
@@ -19,14 +92,14 @@ func GetAllNetworksHandler() http.HandlerFunc {
 		}
 		defer cli.Close()
 
-		networks, err := cli.NetworkList(context.Background(), network.ListOptions{})
+		networks, err := cli.NetworkList(r.Context(), network.ListOptions{})
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
 
-		if err := response.WriteJSONResponse(w, http.StatusOK, networks); err != nil {
+		page := applyListQuery(networks, query, networkListFields)
+		if err := response.WriteJSONResponse(w, http.StatusOK, page); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
@@ -59,3 +132,328 @@ func GetNetworkByParams() http.HandlerFunc {
 		}
 	}
 }
+
+func (req CreateNetworkRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	switch {
+	case req.Name == "":
+		errs = append(errs, response.FieldError{Field: "name", Message: "is required"})
+	case !networkNamePattern.MatchString(req.Name):
+		errs = append(errs, response.FieldError{Field: "name", Message: "must start with a letter or digit and contain only letters, digits, '_', '.' and '-'"})
+	case predefinedNetworks[req.Name]:
+		errs = append(errs, response.FieldError{Field: "name", Message: "is reserved for a network the daemon creates"})
+	}
+	for key := range req.Labels {
+		if key == "" {
+			errs = append(errs, response.FieldError{Field: "labels", Message: "keys must not be empty"})
+			break
+		}
+	}
+
+	if req.Subnet == "" {
+		if req.Gateway != "" {
+			errs = append(errs, response.FieldError{Field: "gateway", Message: "requires a subnet"})
+		}
+		if req.IPRange != "" {
+			errs = append(errs, response.FieldError{Field: "ip_range", Message: "requires a subnet"})
+		}
+		return errs
+	}
+	subnet, err := netip.ParsePrefix(req.Subnet)
+	if err != nil || subnet != subnet.Masked() {
+		return append(errs, response.FieldError{Field: "subnet", Message: "must be a network in CIDR notation, e.g. 172.28.0.0/16"})
+	}
+	if req.Gateway != "" {
+		gateway, err := netip.ParseAddr(req.Gateway)
+		if err != nil || !subnet.Contains(gateway) {
+			errs = append(errs, response.FieldError{Field: "gateway", Message: "must be an address within the subnet"})
+		}
+	}
+	if req.IPRange != "" {
+		ipRange, err := netip.ParsePrefix(req.IPRange)
+		if err != nil || ipRange != ipRange.Masked() || ipRange.Bits() < subnet.Bits() || !subnet.Contains(ipRange.Addr()) {
+			errs = append(errs, response.FieldError{Field: "ip_range", Message: "must be a network in CIDR notation within the subnet"})
+		}
+	}
+
+	return errs
+}
+
+// CreateNetworkHandler creates a network and answers with it as inspected.
+// A network of the same name is refused with 409.
+func CreateNetworkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateNetworkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// Names are matched exactly; inspecting by name would also match
+		// an ID prefix.
+		existing, err := cli.NetworkList(r.Context(), network.ListOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if slices.ContainsFunc(existing, func(n network.Summary) bool { return n.Name == req.Name }) {
+			_ = response.WriteDockerError(w, fmt.Errorf("network %q already exists: %w", req.Name, cerrdefs.ErrAlreadyExists))
+			return
+		}
+
+		opts := network.CreateOptions{
+			Driver:     req.Driver,
+			Internal:   req.Internal,
+			Attachable: req.Attachable,
+			Labels:     req.Labels,
+		}
+		if req.Subnet != "" {
+			opts.IPAM = &network.IPAM{
+				Driver: "default",
+				Config: []network.IPAMConfig{{Subnet: req.Subnet, Gateway: req.Gateway, IPRange: req.IPRange}},
+			}
+			if subnet := netip.MustParsePrefix(req.Subnet); subnet.Addr().Is6() {
+				enableIPv6 := true
+				opts.EnableIPv6 = &enableIPv6
+			}
+		}
+
+		created, err := cli.NetworkCreate(r.Context(), req.Name, opts)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		info, err := cli.NetworkInspect(r.Context(), created.ID, network.InspectOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, info)
+	}
+}
+
+// RemoveNetworkHandler removes a network. A network that containers are
+// still connected to is refused with 409 unless force=true, which
+// disconnects them first.
+func RemoveNetworkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, err := queryBool(r, "force")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.NetworkInspect(r.Context(), r.PathValue("id"), network.InspectOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if predefinedNetworks[info.Name] {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is a network the daemon creates and cannot be removed", info.Name)))
+			return
+		}
+
+		endpoints := networkEndpointNames(info)
+		if len(endpoints) > 0 && !force {
+			err := fmt.Errorf("%w: network %s still has active endpoints: %s; disconnect them or set force", cerrdefs.ErrConflict, info.Name, strings.Join(endpoints, ", "))
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := RemoveNetworkResponse{Name: info.Name, DisconnectedContainers: []string{}}
+		var errs []error
+		for id, endpoint := range info.Containers {
+			if err := cli.NetworkDisconnect(r.Context(), info.ID, id, true); err != nil && !cerrdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("disconnecting container %s: %w", endpoint.Name, err))
+				continue
+			}
+			resp.DisconnectedContainers = append(resp.DisconnectedContainers, endpoint.Name)
+		}
+		slices.Sort(resp.DisconnectedContainers)
+		if len(errs) > 0 {
+			_ = response.WriteDockerError(w, errors.Join(errs...))
+			return
+		}
+
+		if err := cli.NetworkRemove(r.Context(), info.ID); err != nil {
+			// A container may have connected since the network was
+			// inspected; the daemon reports that as forbidden.
+			if strings.Contains(err.Error(), "active endpoints") {
+				err = fmt.Errorf("%w: %s", cerrdefs.ErrConflict, err.Error())
+			}
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+func (req ConnectNetworkRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	if req.Container == "" {
+		errs = append(errs, response.FieldError{Field: "container", Message: "is required"})
+	}
+	for _, alias := range req.Aliases {
+		if alias == "" || strings.ContainsAny(alias, " \t/") {
+			errs = append(errs, response.FieldError{Field: "aliases", Message: "must be non-empty host names"})
+			break
+		}
+	}
+	if req.IPv4Address != "" {
+		if addr, err := netip.ParseAddr(req.IPv4Address); err != nil || !addr.Is4() {
+			errs = append(errs, response.FieldError{Field: "ipv4_address", Message: "must be an IPv4 address"})
+		}
+	}
+	if req.IPv6Address != "" {
+		if addr, err := netip.ParseAddr(req.IPv6Address); err != nil || !addr.Is6() || addr.Is4In6() {
+			errs = append(errs, response.FieldError{Field: "ipv6_address", Message: "must be an IPv6 address"})
+		}
+	}
+
+	return errs
+}
+
+// ConnectNetworkHandler connects a container to a network. A static address
+// must come from a subnet configured on the network; the daemon refuses it
+// otherwise.
+func ConnectNetworkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConnectNetworkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.NetworkInspect(r.Context(), r.PathValue("id"), network.InspectOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		ctr, err := cli.ContainerInspect(r.Context(), req.Container)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		name := strings.TrimPrefix(ctr.Name, "/")
+		if _, ok := ctr.NetworkSettings.Networks[info.Name]; ok {
+			_ = response.WriteDockerError(w, fmt.Errorf("%w: container %s is already connected to network %s", cerrdefs.ErrConflict, name, info.Name))
+			return
+		}
+
+		settings := &network.EndpointSettings{Aliases: req.Aliases}
+		if req.IPv4Address != "" || req.IPv6Address != "" {
+			settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: req.IPv4Address, IPv6Address: req.IPv6Address}
+		}
+		if err := cli.NetworkConnect(r.Context(), info.ID, ctr.ID, settings); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := NetworkEndpointResponse{Network: info.Name, Container: name}
+		if ctr, err = cli.ContainerInspect(r.Context(), ctr.ID); err == nil {
+			resp.Endpoint = ctr.NetworkSettings.Networks[info.Name]
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// DisconnectNetworkHandler disconnects a container from a network. Force
+// disconnects a container the daemon can no longer reach, such as one on a
+// node that has left the swarm.
+func DisconnectNetworkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DisconnectNetworkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Container == "" {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse([]response.FieldError{{Field: "container", Message: "is required"}}))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.NetworkInspect(r.Context(), r.PathValue("id"), network.InspectOptions{})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		ctr, err := cli.ContainerInspect(r.Context(), req.Container)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		name := strings.TrimPrefix(ctr.Name, "/")
+		if _, ok := ctr.NetworkSettings.Networks[info.Name]; !ok {
+			_ = response.WriteDockerError(w, fmt.Errorf("%w: container %s is not connected to network %s", cerrdefs.ErrConflict, name, info.Name))
+			return
+		}
+
+		if err := cli.NetworkDisconnect(r.Context(), info.ID, ctr.ID, req.Force); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, NetworkEndpointResponse{Network: info.Name, Container: name})
+	}
+}
+
+// networkEndpointNames returns the names of the containers connected to a
+// network, sorted.
+func networkEndpointNames(info network.Inspect) []string {
+	names := make([]string, 0, len(info.Containers))
+	for id, endpoint := range info.Containers {
+		name := endpoint.Name
+		if name == "" {
+			name = id
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
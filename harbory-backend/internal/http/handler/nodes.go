@@ -6,11 +6,36 @@ import (
 
 	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 )
 
+// nodeListFields filters swarm nodes by their state ("ready", "down", ...)
+// and hostname. Nodes are sorted by hostname unless asked otherwise.
+var nodeListFields = listFields[swarm.Node]{
+	ID:     func(n swarm.Node) string { return n.ID },
+	Names:  func(n swarm.Node) []string { return []string{n.Description.Hostname} },
+	Labels: func(n swarm.Node) map[string]string { return n.Spec.Labels },
+	Status: func(n swarm.Node) string { return string(n.Status.State) },
+	Sorts: map[string]func(a, b swarm.Node) int{
+		"name":    byString(func(n swarm.Node) string { return n.Description.Hostname }),
+		"status":  byString(func(n swarm.Node) string { return string(n.Status.State) }),
+		"role":    byString(func(n swarm.Node) string { return string(n.Spec.Role) }),
+		"created": func(a, b swarm.Node) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	},
+	DefaultSort: "name",
+}
+
+// GetAllNodesHandler lists swarm nodes; see ListQuery for the supported query
+// parameters.
 func GetAllNodesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseListQuery(r, nodeListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
 		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
@@ -19,14 +44,14 @@ func GetAllNodesHandler() http.HandlerFunc {
 		}
 		defer cli.Close()
 
-		nodes, err := cli.NodeList(context.Background(), types.NodeListOptions{})
+		nodes, err := cli.NodeList(r.Context(), types.NodeListOptions{})
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
 
-		if err := response.WriteJSONResponse(w, http.StatusOK, nodes); err != nil {
+		page := applyListQuery(nodes, query, nodeListFields)
+		if err := response.WriteJSONResponse(w, http.StatusOK, page); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
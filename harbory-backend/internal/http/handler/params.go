@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+// invalidParameter marks err as a client error so response.WriteDockerError
+// reports it as 400 Bad Request, the same way it treats Docker's own
+// validation failures.
+func invalidParameter(err error) error {
+	return fmt.Errorf("%w: %w", cerrdefs.ErrInvalidArgument, err)
+}
+
+// queryBool parses an optional boolean query parameter. A missing parameter is
+// false; anything strconv.ParseBool rejects is an invalid parameter.
+func queryBool(r *http.Request, name string) (bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, invalidParameter(fmt.Errorf("invalid %s %q: must be true or false", name, raw))
+	}
+
+	return value, nil
+}
+
+// queryUint parses an optional non-negative integer query parameter. A
+// missing parameter is 0.
+func queryUint(r *http.Request, name string) (uint, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, invalidParameter(fmt.Errorf("invalid %s %q: must be a non-negative number", name, raw))
+	}
+
+	return uint(value), nil
+}
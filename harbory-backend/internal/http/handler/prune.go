@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// anonymousVolumeLabel marks volumes Docker created for a container without
+// a name; by default only these are pruned.
+const anonymousVolumeLabel = "com.docker.volume.anonymous"
+
+// predefinedNetworks are created by the daemon and cannot be removed.
+var predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+type PruneItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// PruneResponse lists what was deleted, or for a dry run what would be. The
+// space a dry run reports is an estimate: layers shared between images are
+// only reclaimed once the last image using them is gone.
+type PruneResponse struct {
+	DryRun         bool        `json:"dry_run"`
+	Deleted        []PruneItem `json:"deleted"`
+	Count          int         `json:"count"`
+	SpaceReclaimed uint64      `json:"space_reclaimed"`
+}
+
+// pruneOptions are read from the query string: "all" widens what is pruned
+// (unused rather than dangling images, named as well as anonymous volumes,
+// all build cache) and "label" may be repeated to narrow it.
+type pruneOptions struct {
+	All    bool
+	Labels []string
+}
+
+func (o pruneOptions) filters() filters.Args {
+	args := filters.NewArgs()
+	for _, label := range o.Labels {
+		args.Add("label", label)
+	}
+	return args
+}
+
+// pruner finds the resources a prune would delete and runs the prune itself,
+// returning the IDs Docker deleted and the space it reclaimed.
+type pruner struct {
+	candidates func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error)
+	prune      func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error)
+	noLabels   bool
+}
+
+// PruneContainersHandler removes all stopped containers. Harbory's helper
+// containers, which may be waiting in the created state while in use, are
+// left alone.
+func PruneContainersHandler() http.HandlerFunc {
+	return pruneHandler(pruner{
+		candidates: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error) {
+			containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Size: true, Filters: opts.filters()})
+			if err != nil {
+				return nil, err
+			}
+			containers = withoutHelpers(containers)
+			var items []PruneItem
+			for _, c := range containers {
+				switch c.State {
+				case container.StateRunning, container.StatePaused, container.StateRestarting:
+					continue
+				}
+				items = append(items, PruneItem{ID: c.ID, Name: strings.Join(containerNames(c), ","), Size: c.SizeRw})
+			}
+			return items, nil
+		},
+		prune: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error) {
+			args := opts.filters()
+			args.Add("label!", helperLabel)
+			report, err := cli.ContainersPrune(ctx, args)
+			return report.ContainersDeleted, report.SpaceReclaimed, err
+		},
+	})
+}
+
+// PruneImagesHandler removes dangling images, or with all=true every image
+// no container uses.
+func PruneImagesHandler() http.HandlerFunc {
+	return pruneHandler(pruner{
+		candidates: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error) {
+			usage, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.ImageObject}})
+			if err != nil {
+				return nil, err
+			}
+			var items []PruneItem
+			for _, i := range usage.Images {
+				tags := imageTags(*i)
+				if i.Containers > 0 || (!opts.All && len(tags) > 0) || !labelsMatch(i.Labels, opts.Labels) {
+					continue
+				}
+				size := i.Size
+				if i.SharedSize > 0 {
+					size -= i.SharedSize
+				}
+				items = append(items, PruneItem{ID: i.ID, Name: strings.Join(tags, ","), Size: size})
+			}
+			return items, nil
+		},
+		prune: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error) {
+			args := opts.filters()
+			args.Add("dangling", fmt.Sprint(!opts.All))
+			report, err := cli.ImagesPrune(ctx, args)
+			var deleted []string
+			for _, item := range report.ImagesDeleted {
+				if item.Deleted != "" {
+					deleted = append(deleted, item.Deleted)
+				}
+			}
+			return deleted, report.SpaceReclaimed, err
+		},
+	})
+}
+
+// PruneVolumesHandler removes anonymous volumes no container uses, or with
+// all=true named ones as well.
+func PruneVolumesHandler() http.HandlerFunc {
+	return pruneHandler(pruner{
+		candidates: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error) {
+			usage, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+			if err != nil {
+				return nil, err
+			}
+			var items []PruneItem
+			for _, v := range usage.Volumes {
+				if v.UsageData != nil && v.UsageData.RefCount > 0 {
+					continue
+				}
+				if _, anonymous := v.Labels[anonymousVolumeLabel]; !opts.All && !anonymous {
+					continue
+				}
+				if !labelsMatch(v.Labels, opts.Labels) {
+					continue
+				}
+				item := PruneItem{ID: v.Name, Name: v.Name}
+				if v.UsageData != nil && v.UsageData.Size > 0 {
+					item.Size = v.UsageData.Size
+				}
+				items = append(items, item)
+			}
+			return items, nil
+		},
+		prune: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error) {
+			args := opts.filters()
+			if opts.All {
+				args.Add("all", "true")
+			}
+			report, err := cli.VolumesPrune(ctx, args)
+			return report.VolumesDeleted, report.SpaceReclaimed, err
+		},
+	})
+}
+
+// PruneNetworksHandler removes networks no container is connected to. The
+// predefined bridge, host and none networks are never removed.
+func PruneNetworksHandler() http.HandlerFunc {
+	return pruneHandler(pruner{
+		candidates: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error) {
+			networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: opts.filters()})
+			if err != nil {
+				return nil, err
+			}
+			var items []PruneItem
+			for _, n := range networks {
+				if predefinedNetworks[n.Name] || n.Ingress {
+					continue
+				}
+				// NetworkList does not report attached containers.
+				info, err := cli.NetworkInspect(ctx, n.ID, network.InspectOptions{})
+				if err != nil {
+					return nil, err
+				}
+				if len(info.Containers) > 0 {
+					continue
+				}
+				items = append(items, PruneItem{ID: n.ID, Name: n.Name})
+			}
+			return items, nil
+		},
+		prune: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error) {
+			report, err := cli.NetworksPrune(ctx, opts.filters())
+			return report.NetworksDeleted, 0, err
+		},
+	})
+}
+
+// PruneBuildCacheHandler removes build cache that is not in use. Without
+// all=true, cache records shared with images are kept.
+func PruneBuildCacheHandler() http.HandlerFunc {
+	return pruneHandler(pruner{
+		candidates: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]PruneItem, error) {
+			usage, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+			if err != nil {
+				return nil, err
+			}
+			var items []PruneItem
+			for _, record := range usage.BuildCache {
+				if record.InUse || (!opts.All && record.Shared) {
+					continue
+				}
+				items = append(items, PruneItem{ID: record.ID, Name: record.Description, Size: record.Size})
+			}
+			return items, nil
+		},
+		prune: func(ctx context.Context, cli *client.Client, opts pruneOptions) ([]string, uint64, error) {
+			report, err := cli.BuildCachePrune(ctx, build.CachePruneOptions{All: opts.All})
+			if err != nil {
+				return nil, 0, err
+			}
+			return report.CachesDeleted, report.SpaceReclaimed, nil
+		},
+		noLabels: true,
+	})
+}
+
+// pruneHandler serves a prune endpoint. With dry_run=true nothing is deleted
+// and the response lists the candidates instead.
+func pruneHandler(p pruner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, err := queryBool(r, "dry_run")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		all, err := queryBool(r, "all")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		opts := pruneOptions{All: all, Labels: r.URL.Query()["label"]}
+		if p.noLabels && len(opts.Labels) > 0 {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("label filter is not supported for this resource")))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// Computing disk usage and pruning both walk the daemon's storage
+		// and can outlast the server's write timeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		candidates, err := p.candidates(r.Context(), cli, opts)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		resp := PruneResponse{DryRun: dryRun, Deleted: []PruneItem{}}
+		if dryRun {
+			for _, item := range candidates {
+				resp.Deleted = append(resp.Deleted, item)
+				if item.Size > 0 {
+					resp.SpaceReclaimed += uint64(item.Size)
+				}
+			}
+			resp.Count = len(resp.Deleted)
+			_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+			return
+		}
+
+		deleted, reclaimed, err := p.prune(r.Context(), cli, opts)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		known := make(map[string]PruneItem, len(candidates))
+		for _, item := range candidates {
+			known[item.ID] = item
+		}
+		for _, id := range deleted {
+			item, ok := known[id]
+			if !ok {
+				item = PruneItem{ID: id}
+			}
+			resp.Deleted = append(resp.Deleted, item)
+		}
+		resp.Count = len(resp.Deleted)
+		resp.SpaceReclaimed = reclaimed
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
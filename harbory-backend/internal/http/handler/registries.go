@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/credentials"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/distribution/reference"
+)
+
+// RegistryRequest creates or updates a stored registry entry. Secret is a
+// password or access token; it is stored encrypted and never returned.
+type RegistryRequest struct {
+	URL      string  `json:"url"` // e.g. "ghcr.io", "https://registry.example.com" or "localhost:5000"
+	Username string  `json:"username"`
+	Secret   *string `json:"secret,omitempty"` // required on create; omit on update to keep the stored one
+}
+
+var registryListFields = listFields[credentials.Registry]{
+	ID:     func(r credentials.Registry) string { return r.ID },
+	Names:  func(r credentials.Registry) []string { return []string{r.Host, r.URL} },
+	Labels: func(r credentials.Registry) map[string]string { return nil },
+	Sorts: map[string]func(a, b credentials.Registry) int{
+		"name":     byString(func(r credentials.Registry) string { return r.Host }),
+		"username": byString(func(r credentials.Registry) string { return r.Username }),
+		"created":  func(a, b credentials.Registry) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	},
+	DefaultSort: "name",
+}
+
+func (req RegistryRequest) Validate(creating bool) []response.FieldError {
+	var errs []response.FieldError
+
+	if req.URL == "" {
+		errs = append(errs, response.FieldError{Field: "url", Message: "is required"})
+	} else {
+		// A registry host is whatever reference.Domain would report for an
+		// image on it; "myregistry" alone would be read as a Docker Hub user.
+		host := credentials.NormalizeHost(req.URL)
+		named, err := reference.ParseNormalizedNamed(host + "/probe")
+		if err != nil || reference.Domain(named) != host {
+			errs = append(errs, response.FieldError{Field: "url", Message: "must be a registry host such as registry.example.com or localhost:5000"})
+		}
+	}
+
+	if req.Username == "" {
+		errs = append(errs, response.FieldError{Field: "username", Message: "is required"})
+	}
+	if creating && (req.Secret == nil || *req.Secret == "") {
+		errs = append(errs, response.FieldError{Field: "secret", Message: "is required"})
+	}
+
+	return errs
+}
+
+// ListRegistriesHandler lists stored registries; see ListQuery for the
+// supported query parameters.
+func ListRegistriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseListQuery(r, registryListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		store, ok := registryStore(w)
+		if !ok {
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, applyListQuery(store.List(), query, registryListFields))
+	}
+}
+
+func GetRegistryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := registryStore(w)
+		if !ok {
+			return
+		}
+
+		entry, err := store.Get(r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, entry)
+	}
+}
+
+// CreateRegistryHandler stores credentials for a registry. Only one entry
+// per registry host is allowed.
+func CreateRegistryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RegistryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(true); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		store, ok := registryStore(w)
+		if !ok {
+			return
+		}
+
+		entry, err := store.Create(req.URL, req.Username, *req.Secret)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, entry)
+	}
+}
+
+// UpdateRegistryHandler replaces a registry's URL and username, and its
+// secret when one is given.
+func UpdateRegistryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RegistryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(false); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		store, ok := registryStore(w)
+		if !ok {
+			return
+		}
+
+		entry, err := store.Update(r.PathValue("id"), req.URL, req.Username, req.Secret)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, entry)
+	}
+}
+
+func DeleteRegistryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := registryStore(w)
+		if !ok {
+			return
+		}
+
+		if err := store.Delete(r.PathValue("id")); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, response.Response{Status: response.StatusOK})
+	}
+}
+
+// registryStore returns the credential store, answering 503 if the server
+// was started without one.
+func registryStore(w http.ResponseWriter) (*credentials.Store, bool) {
+	store := credentials.GetStore()
+	if store == nil {
+		response.SendError(w, http.StatusServiceUnavailable, "Registry credential store is not available")
+		return nil, false
+	}
+	return store, true
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"github.com/PreetinderSinghBadesha/harbory/internal/credentials"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubAuthKey is the server address the Docker CLI and daemon use for
+// Docker Hub credentials.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// RegistryAuth holds credentials passed with a single request. Either a
+// username and password or an identity token may be given.
+type RegistryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identity_token,omitempty"`
+}
+
+func (a *RegistryAuth) isEmpty() bool {
+	return a == nil || (a.Username == "" && a.Password == "" && a.IdentityToken == "")
+}
+
+// registryAuthHeader returns the encoded X-Registry-Auth value for pulling or
+// pushing named. Credentials sent with the request win; otherwise stored
+// credentials for the image's registry are used. An empty string means the
+// registry is accessed anonymously.
+func registryAuthHeader(named reference.Named, auth *RegistryAuth) (string, error) {
+	host := reference.Domain(named)
+
+	var config registry.AuthConfig
+	if !auth.isEmpty() {
+		config = registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+		}
+	} else {
+		stored, ok := storedRegistryAuth(host)
+		if !ok {
+			return "", nil
+		}
+		config = stored
+	}
+
+	config.ServerAddress = registryServerAddress(host)
+	return registry.EncodeAuthConfig(config)
+}
+
+func registryServerAddress(host string) string {
+	if host == "docker.io" {
+		return dockerHubAuthKey
+	}
+	return host
+}
+
+// storedRegistryAuth returns the credentials stored for host; see
+// credentials.Resolve.
+func storedRegistryAuth(host string) (registry.AuthConfig, bool) {
+	auth, ok := credentials.Resolve(host)
+	if !ok {
+		return registry.AuthConfig{}, false
+	}
+	return registry.AuthConfig{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken}, true
+}
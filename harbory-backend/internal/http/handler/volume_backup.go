@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/backups"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// volumeMountPoint is where helper containers mount the volume they work on.
+const volumeMountPoint = "/volume"
+
+// restoreScript extracts the archive on stdin into a staging directory in
+// the volume and only then swaps it for the volume's contents, so a broken
+// upload leaves the volume as it was. It needs room for both copies while it
+// runs; the swap itself is a rename.
+const restoreScript = `set -e
+stage=/volume/.harbory-restore
+rm -rf "$stage"
+mkdir "$stage"
+if ! tar -xf - --numeric-owner -C "$stage"; then
+	rm -rf "$stage"
+	exit 1
+fi
+find /volume -mindepth 1 -maxdepth 1 ! -path "$stage" -exec rm -rf {} +
+find "$stage" -mindepth 1 -maxdepth 1 -exec mv {} /volume/ \;
+chown "$(stat -c %u:%g "$stage")" /volume
+chmod "$(stat -c %a "$stage")" /volume
+rmdir "$stage"`
+
+// RestoreVolumeRequest selects a stored backup to restore. Restores of an
+// uploaded archive send the archive instead.
+type RestoreVolumeRequest struct {
+	Backup string `json:"backup"`
+}
+
+type RestoreVolumeResponse struct {
+	Volume   string `json:"volume"`
+	Created  bool   `json:"created"`          // the volume did not exist and was created
+	Backup   string `json:"backup,omitempty"` // the stored backup restored, if any
+	Replaced bool   `json:"replaced"`         // the volume's previous contents were replaced, not merged
+}
+
+// BackupVolumeHandler archives a volume's contents as a gzip-compressed tar.
+// A helper container mounts the volume read-only and tars it up; the archive
+// is kept in Harbory's backup directory, or with download=true streamed to
+// the client instead. Containers writing to the volume meanwhile can leave
+// the backup inconsistent, so stop them first for a reliable copy.
+func BackupVolumeHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		download, err := queryBool(r, "download")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		var store *backups.Store
+		if !download {
+			var ok bool
+			if store, ok = backupStore(w); !ok {
+				return
+			}
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		info, err := cli.VolumeInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		// Archiving a large volume, or pulling the helper image first, can
+		// take longer than the write timeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		if err := ensureHelperImage(r.Context(), cli, helperImage); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if download {
+			filename := info.Name + "-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", attachmentDisposition(filename))
+			w.WriteHeader(http.StatusOK)
+
+			gz := gzip.NewWriter(w)
+			if err := backupVolume(r.Context(), cli, helperImage, info.Name, gz); err != nil {
+				log.Printf("Error backing up volume %s: %v", info.Name, err)
+				return
+			}
+			if err := gz.Close(); err != nil {
+				log.Printf("Error backing up volume %s: %v", info.Name, err)
+			}
+			return
+		}
+
+		bw, err := store.Create(info.Name)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		gz := gzip.NewWriter(bw)
+		err = backupVolume(r.Context(), cli, helperImage, info.Name, gz)
+		if err == nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			bw.Abort()
+			_ = response.WriteDockerError(w, fmt.Errorf("backing up volume %s: %w", info.Name, err))
+			return
+		}
+
+		backup, err := bw.Commit()
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, backup)
+	}
+}
+
+// RestoreVolumeHandler restores a volume from a stored backup, selected with
+// a JSON RestoreVolumeRequest, or from an uploaded tar archive, plain or
+// gzip-compressed, sent as the raw body or the "file" field of a multipart
+// form. With create=true a missing volume is created first, so a backup can
+// be restored into a new volume. The archive is merged into the volume's
+// contents, unless replace=true, which swaps them for the archive's once it
+// has been extracted in full. A volume mounted by a running container is
+// refused with 409 unless force=true.
+func RestoreVolumeHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		create, err := queryBool(r, "create")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		replace, err := queryBool(r, "replace")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		force, err := queryBool(r, "force")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		// Restoring a large volume can take longer than the write timeout.
+		_ = stream.DisableWriteDeadline(w)
+
+		resp := RestoreVolumeResponse{Volume: r.PathValue("id"), Replaced: replace}
+
+		var archive io.Reader
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/json" {
+			var req RestoreVolumeRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				response.SendError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			if req.Backup == "" {
+				_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse([]response.FieldError{{Field: "backup", Message: "is required"}}))
+				return
+			}
+
+			store, ok := backupStore(w)
+			if !ok {
+				return
+			}
+			backup, f, err := store.Open(req.Backup)
+			if err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			defer f.Close()
+			archive = f
+			resp.Backup = backup.ID
+		} else {
+			if archive, err = uploadedArchive(r); err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		if _, err := cli.VolumeInspect(r.Context(), resp.Volume); err != nil {
+			if !cerrdefs.IsNotFound(err) || !create {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			if !volumeNamePattern.MatchString(resp.Volume) {
+				_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse([]response.FieldError{{Field: "name", Message: "must start with a letter or digit and contain only letters, digits, '_', '.' and '-'"}}))
+				return
+			}
+			if _, err := cli.VolumeCreate(r.Context(), volume.CreateOptions{Name: resp.Volume}); err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			resp.Created = true
+		} else if !force {
+			containers, err := volumeContainers(r.Context(), cli)
+			if err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			var running []VolumeContainer
+			for _, c := range containers[resp.Volume] {
+				if c.State == "running" {
+					running = append(running, c)
+				}
+			}
+			if len(running) > 0 {
+				err := fmt.Errorf("%w: volume %s is in use by running containers %s; stop them first or set force", cerrdefs.ErrConflict, resp.Volume, volumeContainerNames(running))
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		err = ensureHelperImage(r.Context(), cli, helperImage)
+		if err == nil {
+			err = restoreVolume(r.Context(), cli, helperImage, resp.Volume, archive, replace)
+		}
+		if err != nil {
+			if resp.Created {
+				_ = cli.VolumeRemove(context.Background(), resp.Volume, false)
+			}
+			_ = response.WriteDockerError(w, fmt.Errorf("restoring volume %s: %w", resp.Volume, err))
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
+	}
+}
+
+// GetBackupsHandler lists stored backups, newest first, optionally only
+// those of one volume.
+func GetBackupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := backupStore(w)
+		if !ok {
+			return
+		}
+
+		list, err := store.List(r.URL.Query().Get("volume"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, list)
+	}
+}
+
+func GetBackupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := backupStore(w)
+		if !ok {
+			return
+		}
+
+		backup, err := store.Get(r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, backup)
+	}
+}
+
+// DownloadBackupHandler streams a stored backup's archive. Its checksum is
+// sent in a Digest header for clients that want to verify it.
+func DownloadBackupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := backupStore(w)
+		if !ok {
+			return
+		}
+
+		backup, f, err := store.Open(r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer f.Close()
+
+		_ = stream.DisableWriteDeadline(w)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", attachmentDisposition(backup.Filename()))
+		w.Header().Set("Digest", "sha-256="+backup.SHA256)
+		http.ServeContent(w, r, backup.Filename(), backup.CreatedAt, f)
+	}
+}
+
+func DeleteBackupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := backupStore(w)
+		if !ok {
+			return
+		}
+
+		if err := store.Delete(r.PathValue("id")); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// backupVolume writes a tar archive of the volume's contents to w. Owners
+// are kept as numeric IDs, which is what the volume's containers see.
+func backupVolume(ctx context.Context, cli *client.Client, helperImage, name string, w io.Writer) error {
+	config := &container.Config{
+		Image: helperImage,
+		User:  "0:0",
+		Cmd:   []string{"tar", "-cf", "-", "--numeric-owner", "-C", volumeMountPoint, "."},
+	}
+	return streamHelperContainer(ctx, cli, "volume-backup", config, volumeHostConfig(name, true), nil, w)
+}
+
+// restoreVolume extracts a tar archive, plain or gzip-compressed, into the
+// volume. Entries that would land outside the volume are dropped before the
+// archive reaches the helper container.
+func restoreVolume(ctx context.Context, cli *client.Client, helperImage, name string, archive io.Reader, replace bool) error {
+	cmd := []string{"tar", "-xf", "-", "--numeric-owner", "-C", volumeMountPoint}
+	if replace {
+		cmd = []string{"sh", "-c", restoreScript}
+	}
+	config := &container.Config{Image: helperImage, User: "0:0", Cmd: cmd}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(sanitizeVolumeArchive(pw, archive))
+	}()
+
+	return streamHelperContainer(ctx, cli, "volume-restore", config, volumeHostConfig(name, false), pr, io.Discard)
+}
+
+// sanitizeVolumeArchive copies the archive to w as a plain tar, keeping only
+// entries that stay inside the archive root. Errors reading the archive are
+// the client's.
+func sanitizeVolumeArchive(w io.Writer, archive io.Reader) error {
+	br := bufio.NewReader(archive)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return invalidParameter(fmt.Errorf("reading archive: %w", err))
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	// Writes only fail once the helper container has stopped reading, and
+	// then its own error is the one reported.
+	tw := tar.NewWriter(w)
+	if err := copySafeTar(tw, tar.NewReader(src)); err != nil {
+		return invalidParameter(fmt.Errorf("reading archive: %w", err))
+	}
+	return tw.Close()
+}
+
+func volumeHostConfig(name string, readOnly bool) *container.HostConfig {
+	return &container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:     mount.TypeVolume,
+			Source:   name,
+			Target:   volumeMountPoint,
+			ReadOnly: readOnly,
+		}},
+	}
+}
+
+// backupStore returns the backup store, answering 503 if the server was
+// started without one.
+func backupStore(w http.ResponseWriter) (*backups.Store, bool) {
+	store := backups.GetStore()
+	if store == nil {
+		response.SendError(w, http.StatusServiceUnavailable, "Backup storage is not available")
+		return nil, false
+	}
+	return store, true
+}
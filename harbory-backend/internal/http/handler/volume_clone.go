@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// cloneOfLabel records on a cloned volume which volume it was copied from.
+const cloneOfLabel = "io.harbory.clone-of"
+
+// cloneProgressInterval is how often a clone reports the bytes copied.
+const cloneProgressInterval = 500 * time.Millisecond
+
+type CloneVolumeRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type CloneVolumeResponse struct {
+	Source string        `json:"source"`
+	Volume volume.Volume `json:"volume"`
+	Bytes  int64         `json:"bytes"` // size of the archive streamed between the volumes
+}
+
+// CloneVolumeHandler creates a new volume and copies the source volume's
+// contents into it, keeping numeric owners, modes and timestamps. Like
+// PullImageHandler it answers once the copy has finished, or streams
+// progress to clients that accept text/event-stream. A source mounted
+// read-write by a running container is refused with 409 unless force=true,
+// as the copy would not be consistent; the new volume is removed again if
+// the copy fails.
+func CloneVolumeHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, err := queryBool(r, "force")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		var req CloneVolumeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		source, err := cli.VolumeInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if _, err := cli.VolumeInspect(r.Context(), req.Name); err == nil {
+			_ = response.WriteDockerError(w, fmt.Errorf("volume %q already exists: %w", req.Name, cerrdefs.ErrAlreadyExists))
+			return
+		} else if !cerrdefs.IsNotFound(err) {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		usages, err := volumeUsages(r.Context(), cli, []string{source.Name})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		usage := usages[source.Name]
+		if !force {
+			var writers []VolumeContainer
+			for _, c := range usage.Containers {
+				if c.State == "running" && !c.ReadOnly {
+					writers = append(writers, c)
+				}
+			}
+			if len(writers) > 0 {
+				err := fmt.Errorf("%w: volume %s is being written by running containers %s; stop them for a consistent copy or set force", cerrdefs.ErrConflict, source.Name, volumeContainerNames(writers))
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		serveImageProgress(w, r, func(ctx context.Context, send func(ImageProgressMessage) error) (CloneVolumeResponse, error) {
+			return cloneVolume(ctx, cli, helperImage, source, usage.Size, req, send)
+		}, CloneVolumeResponse.progressMessage)
+	}
+}
+
+func (req CloneVolumeRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	if req.Name == "" {
+		errs = append(errs, response.FieldError{Field: "name", Message: "is required"})
+	}
+	create := CreateVolumeRequest{Name: req.Name, Driver: req.Driver, DriverOpts: req.DriverOpts, Labels: req.Labels}
+	return append(errs, create.Validate()...)
+}
+
+// progressMessage is the "complete" message that ends a streamed clone.
+func (c CloneVolumeResponse) progressMessage() ImageProgressMessage {
+	return ImageProgressMessage{
+		Type:    "complete",
+		ID:      c.Volume.Name,
+		Current: c.Bytes,
+		Message: fmt.Sprintf("Cloned volume %s into %s", c.Source, c.Volume.Name),
+	}
+}
+
+// cloneVolume creates the target volume and streams a tar archive of the
+// source into it, from one helper container to another. size is the
+// source's size, or -1 if unknown, and only serves to report progress.
+func cloneVolume(ctx context.Context, cli *client.Client, helperImage string, source volume.Volume, size int64, req CloneVolumeRequest, send func(ImageProgressMessage) error) (CloneVolumeResponse, error) {
+	_ = send(ImageProgressMessage{Type: "status", Phase: "preparing", Message: "Preparing helper image " + helperImage})
+	if err := ensureHelperImage(ctx, cli, helperImage); err != nil {
+		return CloneVolumeResponse{}, err
+	}
+
+	labels := map[string]string{cloneOfLabel: source.Name}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	target, err := cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       req.Name,
+		Driver:     req.Driver,
+		DriverOpts: req.DriverOpts,
+		Labels:     labels,
+	})
+	if err != nil {
+		return CloneVolumeResponse{}, err
+	}
+
+	progress := &cloneProgress{send: send, total: max(size, 0)}
+	if err := copyVolume(ctx, cli, helperImage, source.Name, target.Name, progress); err != nil {
+		_ = cli.VolumeRemove(context.Background(), target.Name, true)
+		return CloneVolumeResponse{}, fmt.Errorf("cloning volume %s: %w", source.Name, err)
+	}
+	progress.report()
+
+	return CloneVolumeResponse{Source: source.Name, Volume: target, Bytes: progress.written}, nil
+}
+
+// copyVolume pipes a backup of the source straight into a restore of the
+// target, so the contents never touch Harbory's disk.
+func copyVolume(ctx context.Context, cli *client.Client, helperImage, source, target string, progress *cloneProgress) error {
+	pr, pw := io.Pipe()
+	progress.w = pw
+
+	backupErr := make(chan error, 1)
+	go func() {
+		err := backupVolume(ctx, cli, helperImage, source, progress)
+		pw.CloseWithError(err)
+		backupErr <- err
+	}()
+
+	restoreErr := restoreVolume(ctx, cli, helperImage, target, pr, false)
+	if restoreErr == nil {
+		// tar stops reading at the end-of-archive marker; let the source
+		// write its padding.
+		_, _ = io.Copy(io.Discard, pr)
+	}
+	pr.CloseWithError(restoreErr)
+
+	// A failed restore makes the backup fail with that same error, and a
+	// failed backup makes the restore fail reading the archive: report the
+	// side that failed first.
+	if err := <-backupErr; err != nil && !errors.Is(err, restoreErr) {
+		return fmt.Errorf("reading %s: %w", source, err)
+	}
+	return restoreErr
+}
+
+// cloneProgress counts the bytes of the archive passing from one volume to
+// the other and reports them through send, at most every
+// cloneProgressInterval.
+type cloneProgress struct {
+	w       io.Writer
+	send    func(ImageProgressMessage) error
+	total   int64
+	written int64
+	last    time.Time
+}
+
+func (p *cloneProgress) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= cloneProgressInterval {
+		p.last = now
+		p.report()
+	}
+	return n, err
+}
+
+// report sends the bytes copied so far. The archive carries headers and
+// padding on top of the volume's data, so the percentage is capped at 100.
+func (p *cloneProgress) report() {
+	msg := ImageProgressMessage{
+		Type:    "status",
+		Phase:   "copying",
+		Current: p.written,
+		Total:   p.total,
+		Message: "Copying volume contents",
+	}
+	if p.total > 0 {
+		msg.Percent = min(float64(p.written)/float64(p.total)*100, 100)
+	}
+	_ = p.send(msg)
+}
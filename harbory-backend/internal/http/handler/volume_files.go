@@ -0,0 +1,550 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	"github.com/PreetinderSinghBadesha/harbory/internal/utils/stream"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// maxEditableFileBytes caps the files that can be read and written as text.
+const maxEditableFileBytes = 1 << 20
+
+// FileContent is a text file with its metadata.
+type FileContent struct {
+	FileEntry
+	Content string `json:"content"`
+}
+
+// WriteFileRequest replaces, or creates, a text file in a volume. With
+// ModTime set, as read from FileContent, the write is refused if the file has
+// changed since.
+type WriteFileRequest struct {
+	Path    string     `json:"path"`
+	Content string     `json:"content"`
+	ModTime *time.Time `json:"mod_time,omitempty"`
+}
+
+// ListVolumeFilesHandler lists the directory named by the "path" query
+// parameter inside a volume. Paths are relative to the volume's root.
+func ListVolumeFilesHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		// The helper image may have to be pulled first.
+		_ = stream.DisableWriteDeadline(w)
+
+		helperID, volumeName, err := createVolumeFilesHelper(r.Context(), cli, helperImage, r.PathValue("id"), true)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer removeHelperContainer(cli, helperID)
+
+		resolved, stat, err := resolveVolumePath(r.Context(), cli, helperID, dir)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if !stat.Mode.IsDir() {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a directory", volumePath(resolved))))
+			return
+		}
+		listing, err := listVolumeDirectory(r.Context(), cli, helperImage, helperID, volumeName, resolved)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		listing.Path = volumePath(listing.Path)
+		for i := range listing.Entries {
+			listing.Entries[i].Path = volumePath(listing.Entries[i].Path)
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, listing)
+	}
+}
+
+// DownloadVolumeFileHandler downloads the file or directory named by the
+// "path" query parameter. Directories are sent as a tar.gz archive.
+func DownloadVolumeFileHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		_ = stream.DisableWriteDeadline(w)
+
+		helperID, name, err := createVolumeFilesHelper(r.Context(), cli, helperImage, r.PathValue("id"), true)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer removeHelperContainer(cli, helperID)
+
+		resolved, _, err := resolveVolumePath(r.Context(), cli, helperID, p)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		download, err := openContainerDownload(r.Context(), cli, helperID, resolved)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer download.Close()
+		if resolved == volumeMountPoint {
+			download.name = name
+		}
+
+		if err := download.Stream(w); err != nil {
+			log.Printf("Error streaming %s from volume %s: %v", p, name, err)
+		}
+	}
+}
+
+// UploadVolumeFilesHandler copies the files sent in the multipart "file"
+// field into the directory named by the "path" query parameter. They are
+// given the directory's owner, as the volume's containers may not run as
+// root.
+func UploadVolumeFilesHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		form, err := parseUploadForm(w, r)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer form.RemoveAll()
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		helperID, volumeName, err := createVolumeFilesHelper(r.Context(), cli, helperImage, r.PathValue("id"), false)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer removeHelperContainer(cli, helperID)
+
+		resolved, stat, err := resolveVolumePath(r.Context(), cli, helperID, dir)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if !stat.Mode.IsDir() {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a directory", dir)))
+			return
+		}
+		uid, gid, err := volumeDirectoryOwner(r.Context(), cli, helperImage, volumeName, resolved)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		uploaded, err := copyUploads(r.Context(), cli, helperID, resolved, form.File["file"], uid, gid)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		uploaded.Path = volumePath(uploaded.Path)
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, uploaded)
+	}
+}
+
+// GetVolumeFileContentHandler returns the text file named by the "path"
+// query parameter, for editing. Files over 1 MiB and binary files are
+// refused; download those instead.
+func GetVolumeFileContentHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cleanArchivePath(r.URL.Query().Get("path"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		_ = stream.DisableWriteDeadline(w)
+
+		helperID, _, err := createVolumeFilesHelper(r.Context(), cli, helperImage, r.PathValue("id"), true)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer removeHelperContainer(cli, helperID)
+
+		resolved, stat, err := resolveVolumePath(r.Context(), cli, helperID, p)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if !stat.Mode.IsRegular() {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a regular file", p)))
+			return
+		}
+		if stat.Size > maxEditableFileBytes {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is %d bytes, more than the %d that can be edited; download it instead", p, stat.Size, maxEditableFileBytes)))
+			return
+		}
+
+		content, _, err := cli.CopyFromContainer(r.Context(), helperID, resolved)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer content.Close()
+
+		tr := tar.NewReader(content)
+		hdr, err := tr.Next()
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxEditableFileBytes+1))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if !isText(data) {
+			_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a text file; download it instead", p)))
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, FileContent{
+			FileEntry: fileEntryFromHeader(hdr, volumePath(resolved)),
+			Content:   string(data),
+		})
+	}
+}
+
+// WriteVolumeFileContentHandler replaces a text file in a volume, keeping
+// its mode and owner, or creates it with mode 0644 and its directory's
+// owner.
+func WriteVolumeFileContentHandler(helperImage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req WriteFileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+		p, err := cleanArchivePath(req.Path)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if p == "/" {
+			_ = response.WriteDockerError(w, invalidParameter(errors.New("path must name a file")))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		_ = stream.DisableWriteDeadline(w)
+
+		helperID, volumeName, err := createVolumeFilesHelper(r.Context(), cli, helperImage, r.PathValue("id"), false)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		defer removeHelperContainer(cli, helperID)
+
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Mode: 0o644}
+		target, stat, err := resolveVolumePath(r.Context(), cli, helperID, p)
+		switch {
+		case err == nil:
+			if !stat.Mode.IsRegular() {
+				_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a regular file", p)))
+				return
+			}
+			current, err := volumeEntryHeader(r.Context(), cli, helperID, target)
+			if err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			if req.ModTime != nil && !req.ModTime.Equal(current.ModTime) {
+				_ = response.WriteDockerError(w, fmt.Errorf("%w: %s has changed since it was read", cerrdefs.ErrConflict, p))
+				return
+			}
+			hdr.Mode, hdr.Uid, hdr.Gid = current.Mode, current.Uid, current.Gid
+		case cerrdefs.IsNotFound(err):
+			if req.ModTime != nil {
+				_ = response.WriteDockerError(w, fmt.Errorf("%w: %s has been removed since it was read", cerrdefs.ErrConflict, p))
+				return
+			}
+			dir, stat, err := resolveVolumePath(r.Context(), cli, helperID, path.Dir(p))
+			if err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			if !stat.Mode.IsDir() {
+				_ = response.WriteDockerError(w, invalidParameter(fmt.Errorf("%s is not a directory", path.Dir(p))))
+				return
+			}
+			hdr.Uid, hdr.Gid, err = volumeDirectoryOwner(r.Context(), cli, helperImage, volumeName, dir)
+			if err != nil {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+			target = path.Join(dir, path.Base(p))
+		default:
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		hdr.Name = path.Base(target)
+		hdr.Size = int64(len(req.Content))
+		hdr.ModTime = time.Now()
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(hdr); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		_, _ = tw.Write([]byte(req.Content))
+		if err := tw.Close(); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if err := cli.CopyToContainer(r.Context(), helperID, path.Dir(target), &buf, container.CopyToContainerOptions{}); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		// Written back from the header so clients get the new mod_time to
+		// send with their next write.
+		written, err := volumeEntryHeader(r.Context(), cli, helperID, target)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		_ = response.WriteJSONResponse(w, http.StatusOK, fileEntryFromHeader(written, volumePath(target)))
+	}
+}
+
+func (req WriteFileRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	if req.Path == "" {
+		errs = append(errs, response.FieldError{Field: "path", Message: "is required"})
+	}
+	if len(req.Content) > maxEditableFileBytes {
+		errs = append(errs, response.FieldError{Field: "content", Message: fmt.Sprintf("must be at most %d bytes; upload larger files instead", maxEditableFileBytes)})
+	}
+
+	return errs
+}
+
+// createVolumeFilesHelper creates a helper container with the volume mounted
+// at volumeMountPoint, for the archive API to read and write through. It is
+// never started. Callers must remove it.
+func createVolumeFilesHelper(ctx context.Context, cli *client.Client, helperImage, volumeID string, readOnly bool) (string, string, error) {
+	info, err := cli.VolumeInspect(ctx, volumeID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := ensureHelperImage(ctx, cli, helperImage); err != nil {
+		return "", "", err
+	}
+
+	id, err := createHelperContainer(ctx, cli, "volume-files", &container.Config{Image: helperImage}, volumeHostConfig(info.Name, readOnly))
+	if err != nil {
+		return "", "", err
+	}
+	return id, info.Name, nil
+}
+
+// listVolumeDirectory lists dir, a resolved directory in the files helper
+// helperID, by running listDirectoryCommand in a second helper with the
+// volume mounted the same way, so that only that one directory is read.
+func listVolumeDirectory(ctx context.Context, cli *client.Client, helperImage, helperID, volumeName, dir string) (DirectoryListing, error) {
+	result, err := runHelperContainer(ctx, cli, "volume-files", &container.Config{
+		Image: helperImage,
+		User:  "0:0",
+		Cmd:   listDirectoryCommand(dir),
+	}, volumeHostConfig(volumeName, true))
+	if err != nil {
+		return DirectoryListing{}, err
+	}
+	if err := result.err(); err != nil {
+		return DirectoryListing{}, fmt.Errorf("listing %s: %w", volumePath(dir), err)
+	}
+
+	var output listingBuffer
+	_, _ = output.Write(result.Stdout)
+	listing := DirectoryListing{Path: dir}
+	listing.Entries, listing.Truncated = output.parse(dir)
+	fillLinkTargets(ctx, cli, helperID, listing.Entries)
+	sortDirectoryEntries(listing.Entries)
+	return listing, nil
+}
+
+// resolveVolumePath maps p, a clean path relative to the volume's root, to
+// its path in the helper container, following symlinks one component at a
+// time. Links are only followed while they stay inside the volume: absolute
+// targets mean something only to the containers the volume is mounted in.
+func resolveVolumePath(ctx context.Context, cli *client.Client, helperID, p string) (string, container.PathStat, error) {
+	resolved := volumeMountPoint
+	stat, err := cli.ContainerStatPath(ctx, helperID, resolved)
+	if err != nil {
+		return "", stat, err
+	}
+
+	rest := volumePathComponents(p)
+	for hops := 0; len(rest) > 0; {
+		next := path.Join(resolved, rest[0])
+		rest = rest[1:]
+
+		stat, err = cli.ContainerStatPath(ctx, helperID, next)
+		if cerrdefs.IsNotFound(err) {
+			return "", stat, fmt.Errorf("%s does not exist: %w", volumePath(next), cerrdefs.ErrNotFound)
+		}
+		if err != nil {
+			return "", stat, err
+		}
+		if stat.Mode&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", stat, invalidParameter(fmt.Errorf("too many levels of symbolic links resolving %s", p))
+		}
+		target := path.Join(resolved, stat.LinkTarget)
+		if path.IsAbs(stat.LinkTarget) || (target != volumeMountPoint && !strings.HasPrefix(target, volumeMountPoint+"/")) {
+			return "", stat, invalidParameter(fmt.Errorf("%s is a symbolic link to %s, outside the volume", volumePath(next), stat.LinkTarget))
+		}
+		resolved = volumeMountPoint
+		rest = append(volumePathComponents(volumePath(target)), rest...)
+	}
+
+	return resolved, stat, nil
+}
+
+// volumeEntryHeader returns the tar header Docker's archive of p starts
+// with, which carries the owner and exact mode that a stat does not. Use it
+// on files only: the archive of a directory holds everything beneath it.
+func volumeEntryHeader(ctx context.Context, cli *client.Client, helperID, p string) (*tar.Header, error) {
+	content, _, err := cli.CopyFromContainer(ctx, helperID, p)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return tar.NewReader(content).Next()
+}
+
+// volumeDirectoryOwner returns the numeric owner of dir, a resolved
+// directory in a files helper, by running stat in a helper with the volume
+// mounted the same way.
+func volumeDirectoryOwner(ctx context.Context, cli *client.Client, helperImage, volumeName, dir string) (int, int, error) {
+	result, err := runHelperContainer(ctx, cli, "volume-files", &container.Config{
+		Image: helperImage,
+		User:  "0:0",
+		Cmd:   []string{"stat", "-c", "%u %g", dir},
+	}, volumeHostConfig(volumeName, true))
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := result.err(); err != nil {
+		return 0, 0, fmt.Errorf("reading the owner of %s: %w", volumePath(dir), err)
+	}
+
+	var uid, gid int
+	if _, err := fmt.Sscan(string(result.Stdout), &uid, &gid); err != nil {
+		return 0, 0, fmt.Errorf("reading the owner of %s: %w", volumePath(dir), err)
+	}
+	return uid, gid, nil
+}
+
+// volumePath turns a path in a helper container into one relative to the
+// volume's root.
+func volumePath(containerPath string) string {
+	rel := strings.TrimPrefix(containerPath, volumeMountPoint)
+	if rel == "" {
+		return "/"
+	}
+	return rel
+}
+
+func volumePathComponents(p string) []string {
+	trimmed := strings.Trim(p, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// isText reports whether data looks like text that can be edited in a
+// browser: valid UTF-8 without NUL bytes.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
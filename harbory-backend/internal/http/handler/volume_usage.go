@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// VolumeContainer is a container, running or not, that mounts a volume.
+type VolumeContainer struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"read_only"`
+}
+
+// VolumeUsage describes which containers mount a volume and how much space
+// it takes. Size is -1 when the volume driver cannot report it.
+type VolumeUsage struct {
+	Containers []VolumeContainer `json:"containers"`
+	InUse      bool              `json:"in_use"`
+	Size       int64             `json:"size"`
+}
+
+// VolumeListItem is a volume as Docker reports it, with its usage.
+type VolumeListItem struct {
+	volume.Volume
+	Usage VolumeUsage `json:"usage"`
+}
+
+// volumeUsages returns the usage of the named volumes. Sizes come from the
+// daemon's disk usage report, which walks every local volume, so callers
+// should ask for all the volumes they need at once.
+func volumeUsages(ctx context.Context, cli *client.Client, names []string) (map[string]VolumeUsage, error) {
+	containers, err := volumeContainers(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make(map[string]VolumeUsage, len(names))
+	for _, name := range names {
+		usages[name] = VolumeUsage{
+			Containers: append([]VolumeContainer{}, containers[name]...),
+			InUse:      len(containers[name]) > 0,
+			Size:       -1,
+		}
+	}
+	if len(names) == 0 {
+		return usages, nil
+	}
+
+	du, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range du.Volumes {
+		usage, ok := usages[v.Name]
+		if !ok || v.UsageData == nil {
+			continue
+		}
+		usage.Size = v.UsageData.Size
+		usages[v.Name] = usage
+	}
+
+	return usages, nil
+}
+
+// volumeContainers maps each volume name to the containers that mount it.
+func volumeContainers(ctx context.Context, cli *client.Client) (map[string][]VolumeContainer, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	containers = withoutHelpers(containers)
+
+	byVolume := make(map[string][]VolumeContainer)
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if m.Type != mount.TypeVolume || m.Name == "" {
+				continue
+			}
+			byVolume[m.Name] = append(byVolume[m.Name], VolumeContainer{
+				ID:          c.ID,
+				Name:        strings.Join(containerNames(c), ","),
+				State:       c.State,
+				Destination: m.Destination,
+				ReadOnly:    !m.RW,
+			})
+		}
+	}
+	return byVolume, nil
+}
+
+func volumeContainerNames(containers []VolumeContainer) string {
+	names := make([]string, 0, len(containers))
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -1,16 +1,62 @@
 package handler
 
 import(
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"context"
-	
+	"regexp"
+
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/PreetinderSinghBadesha/harbory/internal/utils/response"
+	cerrdefs "github.com/containerd/errdefs"
 )
 
+// volumeNamePattern is the name format the daemon accepts for volumes.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// CreateVolumeRequest creates a volume. Docker picks a random name when Name
+// is empty and uses the "local" driver when Driver is.
+type CreateVolumeRequest struct {
+	Name       string            `json:"name,omitempty"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// RemoveVolumeResponse lists the stopped containers removed along with a
+// volume they referenced, which only happens when the removal was forced.
+type RemoveVolumeResponse struct {
+	Name              string   `json:"name"`
+	RemovedContainers []string `json:"removed_containers"`
+}
+
+// volumeListFields sorts volumes by name unless asked otherwise. Volumes
+// have no status to filter on.
+var volumeListFields = listFields[*volume.Volume]{
+	ID:     func(v *volume.Volume) string { return v.Name },
+	Names:  func(v *volume.Volume) []string { return []string{v.Name} },
+	Labels: func(v *volume.Volume) map[string]string { return v.Labels },
+	Sorts: map[string]func(a, b *volume.Volume) int{
+		"name":    byString(func(v *volume.Volume) string { return v.Name }),
+		"driver":  byString(func(v *volume.Volume) string { return v.Driver }),
+		"created": byString(func(v *volume.Volume) string { return v.CreatedAt }),
+	},
+	DefaultSort: "name",
+}
+
+// GetAllVolumesHandler lists volumes with the containers that mount them and
+// their size; see ListQuery for the supported query parameters.
 func GetAllVolumesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request){
+		query, err := parseListQuery(r, volumeListFields)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
 		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
@@ -19,14 +65,29 @@ func GetAllVolumesHandler() http.HandlerFunc {
 		}
 		defer cli.Close()
 		
-		volumes, err := cli.VolumeList(context.Background(), volume.ListOptions{})
+		volumes, err := cli.VolumeList(r.Context(), volume.ListOptions{})
 		if err != nil {
-			errorResp := response.GeneralErrorResponse(err)
-			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			_ = response.WriteDockerError(w, err)
 			return
 		}
-		
-		if err := response.WriteJSONResponse(w, http.StatusOK, volumes); err != nil {
+
+		page := applyListQuery(volumes.Volumes, query, volumeListFields)
+		names := make([]string, 0, len(page.Items))
+		for _, v := range page.Items {
+			names = append(names, v.Name)
+		}
+		usages, err := volumeUsages(r.Context(), cli, names)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		items := make([]VolumeListItem, 0, len(page.Items))
+		for _, v := range page.Items {
+			items = append(items, VolumeListItem{Volume: *v, Usage: usages[v.Name]})
+		}
+
+		resp := ListPage[VolumeListItem]{Items: items, Total: page.Total, Limit: page.Limit, NextCursor: page.NextCursor, Warnings: volumes.Warnings}
+		if err := response.WriteJSONResponse(w, http.StatusOK, resp); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
@@ -45,17 +106,168 @@ func GetVolumeByParams() http.HandlerFunc {
 		defer cli.Close()
 		
 		volumeID := r.PathValue("id")
-		volume, _, err := cli.VolumeInspectWithRaw(context.Background(), volumeID)
+		volume, err := cli.VolumeInspect(r.Context(), volumeID)
 		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		usages, err := volumeUsages(r.Context(), cli, []string{volume.Name})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		if err := response.WriteJSONResponse(w, http.StatusOK, VolumeListItem{Volume: volume, Usage: usages[volume.Name]}); err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
 		}
-		
-		if err := response.WriteJSONResponse(w, http.StatusOK, volume); err != nil {
+	}
+}
+
+func (req CreateVolumeRequest) Validate() []response.FieldError {
+	var errs []response.FieldError
+
+	if req.Name != "" && !volumeNamePattern.MatchString(req.Name) {
+		errs = append(errs, response.FieldError{Field: "name", Message: "must start with a letter or digit and contain only letters, digits, '_', '.' and '-'"})
+	}
+	for key := range req.Labels {
+		if key == "" {
+			errs = append(errs, response.FieldError{Field: "labels", Message: "keys must not be empty"})
+			break
+		}
+	}
+	for key := range req.DriverOpts {
+		if key == "" {
+			errs = append(errs, response.FieldError{Field: "driver_opts", Message: "keys must not be empty"})
+			break
+		}
+	}
+
+	return errs
+}
+
+// CreateVolumeHandler creates a volume. Unlike `docker volume create`, which
+// quietly returns an existing volume of the same name, it answers 409.
+func CreateVolumeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateVolumeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := req.Validate(); len(fieldErrs) > 0 {
+			_ = response.WriteJSONResponse(w, http.StatusBadRequest, response.ValidationErrorResponse(fieldErrs))
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			errorResp := response.GeneralErrorResponse(err)
+			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
+			return
+		}
+		defer cli.Close()
+
+		if req.Name != "" {
+			_, err := cli.VolumeInspect(r.Context(), req.Name)
+			if err == nil {
+				_ = response.WriteDockerError(w, fmt.Errorf("volume %q already exists: %w", req.Name, cerrdefs.ErrAlreadyExists))
+				return
+			}
+			if !cerrdefs.IsNotFound(err) {
+				_ = response.WriteDockerError(w, err)
+				return
+			}
+		}
+
+		created, err := cli.VolumeCreate(r.Context(), volume.CreateOptions{
+			Name:       req.Name,
+			Driver:     req.Driver,
+			DriverOpts: req.DriverOpts,
+			Labels:     req.Labels,
+		})
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusCreated, created)
+	}
+}
+
+// RemoveVolumeHandler removes a volume. A volume referenced by any container
+// is refused with 409 unless force=true, which removes the stopped containers
+// that reference it first; a volume mounted by a running container is always
+// refused. Forcing also has the daemon remove the volume even if its driver
+// fails to clean it up.
+func RemoveVolumeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, err := queryBool(r, "force")
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
 			errorResp := response.GeneralErrorResponse(err)
 			_ = response.WriteJSONResponse(w, http.StatusInternalServerError, errorResp)
 			return
 		}
+		defer cli.Close()
+
+		info, err := cli.VolumeInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		containers, err := volumeContainers(r.Context(), cli)
+		if err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		users := containers[info.Name]
+		var running []VolumeContainer
+		for _, c := range users {
+			if c.State == "running" || c.State == "paused" || c.State == "restarting" {
+				running = append(running, c)
+			}
+		}
+		if len(running) > 0 {
+			err := fmt.Errorf("%w: volume %s is in use by running containers %s; stop or remove them first", cerrdefs.ErrConflict, info.Name, volumeContainerNames(running))
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+		if len(users) > 0 && !force {
+			err := fmt.Errorf("%w: volume %s is referenced by stopped containers %s; set force to remove them and the volume", cerrdefs.ErrConflict, info.Name, volumeContainerNames(users))
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		// Without Force the daemon refuses a container started since it was
+		// listed, rather than killing it.
+		resp := RemoveVolumeResponse{Name: info.Name, RemovedContainers: []string{}}
+		var errs []error
+		for _, c := range users {
+			if err := cli.ContainerRemove(r.Context(), c.ID, container.RemoveOptions{}); err != nil && !cerrdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("removing container %s: %w", c.Name, err))
+				continue
+			}
+			resp.RemovedContainers = append(resp.RemovedContainers, c.Name)
+		}
+		if len(errs) > 0 {
+			_ = response.WriteDockerError(w, errors.Join(errs...))
+			return
+		}
+
+		if err := cli.VolumeRemove(r.Context(), info.Name, force); err != nil {
+			_ = response.WriteDockerError(w, err)
+			return
+		}
+
+		_ = response.WriteJSONResponse(w, http.StatusOK, resp)
 	}
-}
\ No newline at end of file
+}
@@ -4,11 +4,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/PreetinderSinghBadesha/harbory/internal/config"
 	"github.com/PreetinderSinghBadesha/harbory/internal/http/handler"
 	"github.com/PreetinderSinghBadesha/harbory/internal/middleware"
 )
 
-func Router(startTime time.Time) *http.ServeMux {
+func Router(cfg *config.Config, startTime time.Time) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Public routes (no authentication required)
@@ -27,19 +28,89 @@ func Router(startTime time.Time) *http.ServeMux {
 
 	// router for containers
 	mux.HandleFunc("GET /api/containers", middleware.AuthMiddleware(handler.GetAllContainersHandler()))
+	mux.HandleFunc("POST /api/containers", middleware.AuthMiddleware(handler.CreateContainerHandler()))
+	mux.HandleFunc("GET /api/containers/stats", middleware.AuthMiddleware(handler.GetAllContainerStatsHandler()))
+	mux.HandleFunc("POST /api/containers/bulk", middleware.AuthMiddleware(handler.BulkContainersHandler()))
+	mux.HandleFunc("POST /api/containers/prune", middleware.AuthMiddleware(handler.PruneContainersHandler()))
+	mux.HandleFunc("GET /api/containers/updates", middleware.AuthMiddleware(handler.GetContainerUpdatesHandler()))
+	mux.HandleFunc("POST /api/containers/updates/check", middleware.AuthMiddleware(handler.CheckContainerUpdatesHandler()))
 	mux.HandleFunc("GET /api/containers/{id}", middleware.AuthMiddleware(handler.GetContainerByParams()))
+	mux.HandleFunc("POST /api/containers/{id}/start", middleware.AuthMiddleware(handler.StartContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/stop", middleware.AuthMiddleware(handler.StopContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/restart", middleware.AuthMiddleware(handler.RestartContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/pause", middleware.AuthMiddleware(handler.PauseContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/unpause", middleware.AuthMiddleware(handler.UnpauseContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/kill", middleware.AuthMiddleware(handler.KillContainerHandler()))
+	mux.HandleFunc("DELETE /api/containers/{id}", middleware.AuthMiddleware(handler.RemoveContainerHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/recreate", middleware.AuthMiddleware(handler.RecreateContainerHandler()))
+	mux.HandleFunc("PATCH /api/containers/{id}/resources", middleware.AuthMiddleware(handler.UpdateContainerResourcesHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/fs", middleware.AuthMiddleware(handler.ListContainerFilesHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/fs/download", middleware.AuthMiddleware(handler.DownloadContainerFileHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/fs/upload", middleware.AuthMiddleware(handler.UploadContainerFilesHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/changes", middleware.AuthMiddleware(handler.GetContainerChangesHandler()))
+	mux.HandleFunc("POST /api/containers/{id}/commit", middleware.AuthMiddleware(handler.CommitContainerHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/export", middleware.AuthMiddleware(handler.ExportContainerHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/logs", middleware.AuthMiddleware(handler.ContainerLogsHandler()))
+	mux.Handle("/api/containers/{id}/exec", middleware.AuthMiddlewareHandler(handler.ContainerExecWebSocketHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/stats", middleware.AuthMiddleware(handler.GetContainerStatsHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/top", middleware.AuthMiddleware(handler.GetContainerTopHandler()))
+	mux.HandleFunc("GET /api/containers/{id}/update", middleware.AuthMiddleware(handler.GetContainerUpdateHandler()))
 
 	// router for images
 	mux.HandleFunc("GET /api/images", middleware.AuthMiddleware(handler.GetAllImagesHandler()))
+	mux.HandleFunc("POST /api/images/bulk", middleware.AuthMiddleware(handler.BulkImagesHandler()))
+	mux.HandleFunc("POST /api/images/prune", middleware.AuthMiddleware(handler.PruneImagesHandler()))
+	mux.HandleFunc("GET /api/images/cleanup", middleware.AuthMiddleware(handler.GetImageCleanupHandler()))
+	mux.HandleFunc("POST /api/images/cleanup", middleware.AuthMiddleware(handler.CleanupImagesHandler()))
+	mux.HandleFunc("POST /api/images/pull", middleware.AuthMiddleware(handler.PullImageHandler()))
+	mux.HandleFunc("POST /api/images/import", middleware.AuthMiddleware(handler.ImportImagesHandler()))
+	mux.Handle("/api/images/pull/ws", middleware.AuthMiddlewareHandler(handler.PullImageWebSocketHandler()))
 	mux.HandleFunc("GET /api/images/{id}", middleware.AuthMiddleware(handler.GetImageByParams()))
+	mux.HandleFunc("DELETE /api/images/{id}", middleware.AuthMiddleware(handler.RemoveImageHandler()))
+	mux.HandleFunc("POST /api/images/{id}/tag", middleware.AuthMiddleware(handler.TagImageHandler()))
+	mux.HandleFunc("POST /api/images/{id}/push", middleware.AuthMiddleware(handler.PushImageHandler()))
+	mux.HandleFunc("GET /api/images/{id}/history", middleware.AuthMiddleware(handler.GetImageHistoryHandler()))
+	mux.HandleFunc("GET /api/images/{id}/export", middleware.AuthMiddleware(handler.ExportImagesHandler()))
+	mux.HandleFunc("GET /api/images/{id}/sbom", middleware.AuthMiddleware(handler.GetImageSBOMHandler()))
 
 	//router for volumes
 	mux.HandleFunc("GET /api/volumes", middleware.AuthMiddleware(handler.GetAllVolumesHandler()))
+	mux.HandleFunc("POST /api/volumes", middleware.AuthMiddleware(handler.CreateVolumeHandler()))
+	mux.HandleFunc("POST /api/volumes/bulk", middleware.AuthMiddleware(handler.BulkVolumesHandler()))
+	mux.HandleFunc("POST /api/volumes/prune", middleware.AuthMiddleware(handler.PruneVolumesHandler()))
 	mux.HandleFunc("GET /api/volumes/{id}", middleware.AuthMiddleware(handler.GetVolumeByParams()))
+	mux.HandleFunc("DELETE /api/volumes/{id}", middleware.AuthMiddleware(handler.RemoveVolumeHandler()))
+	mux.HandleFunc("POST /api/volumes/{id}/backup", middleware.AuthMiddleware(handler.BackupVolumeHandler(cfg.Helper.Image)))
+	mux.HandleFunc("POST /api/volumes/{id}/restore", middleware.AuthMiddleware(handler.RestoreVolumeHandler(cfg.Helper.Image)))
+	mux.HandleFunc("POST /api/volumes/{id}/clone", middleware.AuthMiddleware(handler.CloneVolumeHandler(cfg.Helper.Image)))
+	mux.HandleFunc("GET /api/volumes/{id}/fs", middleware.AuthMiddleware(handler.ListVolumeFilesHandler(cfg.Helper.Image)))
+	mux.HandleFunc("GET /api/volumes/{id}/fs/download", middleware.AuthMiddleware(handler.DownloadVolumeFileHandler(cfg.Helper.Image)))
+	mux.HandleFunc("POST /api/volumes/{id}/fs/upload", middleware.AuthMiddleware(handler.UploadVolumeFilesHandler(cfg.Helper.Image)))
+	mux.HandleFunc("GET /api/volumes/{id}/fs/content", middleware.AuthMiddleware(handler.GetVolumeFileContentHandler(cfg.Helper.Image)))
+	mux.HandleFunc("PUT /api/volumes/{id}/fs/content", middleware.AuthMiddleware(handler.WriteVolumeFileContentHandler(cfg.Helper.Image)))
+
+	//router for volume backups
+	mux.HandleFunc("GET /api/backups", middleware.AuthMiddleware(handler.GetBackupsHandler()))
+	mux.HandleFunc("GET /api/backups/{id}", middleware.AuthMiddleware(handler.GetBackupHandler()))
+	mux.HandleFunc("GET /api/backups/{id}/download", middleware.AuthMiddleware(handler.DownloadBackupHandler()))
+	mux.HandleFunc("DELETE /api/backups/{id}", middleware.AuthMiddleware(handler.DeleteBackupHandler()))
 
 	//router for networks
 	mux.HandleFunc("GET /api/networks", middleware.AuthMiddleware(handler.GetAllNetworksHandler()))
+	mux.HandleFunc("POST /api/networks", middleware.AuthMiddleware(handler.CreateNetworkHandler()))
+	mux.HandleFunc("POST /api/networks/bulk", middleware.AuthMiddleware(handler.BulkNetworksHandler()))
+	mux.HandleFunc("POST /api/networks/prune", middleware.AuthMiddleware(handler.PruneNetworksHandler()))
 	mux.HandleFunc("GET /api/networks/{id}", middleware.AuthMiddleware(handler.GetNetworkByParams()))
+	mux.HandleFunc("DELETE /api/networks/{id}", middleware.AuthMiddleware(handler.RemoveNetworkHandler()))
+	mux.HandleFunc("POST /api/networks/{id}/connect", middleware.AuthMiddleware(handler.ConnectNetworkHandler()))
+	mux.HandleFunc("POST /api/networks/{id}/disconnect", middleware.AuthMiddleware(handler.DisconnectNetworkHandler()))
+
+	//router for registry credentials
+	mux.HandleFunc("GET /api/registries", middleware.AuthMiddleware(handler.ListRegistriesHandler()))
+	mux.HandleFunc("POST /api/registries", middleware.AuthMiddleware(handler.CreateRegistryHandler()))
+	mux.HandleFunc("GET /api/registries/{id}", middleware.AuthMiddleware(handler.GetRegistryHandler()))
+	mux.HandleFunc("PUT /api/registries/{id}", middleware.AuthMiddleware(handler.UpdateRegistryHandler()))
+	mux.HandleFunc("DELETE /api/registries/{id}", middleware.AuthMiddleware(handler.DeleteRegistryHandler()))
 
 	//router for nodes
 	mux.HandleFunc("GET /api/nodes", middleware.AuthMiddleware(handler.GetAllNodesHandler()))
@@ -53,8 +124,12 @@ func Router(startTime time.Time) *http.ServeMux {
 	mux.HandleFunc("POST /api/github/search", handler.GithubSearchHandler())
 	mux.HandleFunc("POST /api/github/user/repos", handler.GithubUserReposHandler())
 
+	//router for Docker events
+	mux.HandleFunc("GET /api/events", middleware.AuthMiddleware(handler.DockerEventsHandler()))
+
 	//router for system stats
 	mux.HandleFunc("GET /api/system/stats", middleware.AuthMiddleware(handler.GetSystemStatsHandler()))
+	mux.HandleFunc("POST /api/build/prune", middleware.AuthMiddleware(handler.PruneBuildCacheHandler()))
 
 	return mux
 }
@@ -0,0 +1,392 @@
+// Package updates checks whether the images of running containers have been
+// updated in their registries, by comparing the digest each container's image
+// was pulled by with the digest its tag points to now.
+package updates
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/config"
+	"github.com/PreetinderSinghBadesha/harbory/internal/credentials"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// Statuses a container can have.
+const (
+	StatusUpToDate        = "up_to_date"
+	StatusUpdateAvailable = "update_available"
+	StatusSkipped         = "skipped" // cannot be checked, see Reason
+	StatusError           = "error"
+	StatusUnchecked       = "unchecked"
+)
+
+// firstCheckDelay lets the server finish starting before the first
+// scheduled check.
+const firstCheckDelay = time.Minute
+
+// helperLabel marks the helper containers Harbory runs for its own work; see
+// the handler package.
+const helperLabel = "io.harbory.helper"
+
+// ContainerStatus is the result of checking one container.
+type ContainerStatus struct {
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	Image         string    `json:"image"` // the reference the container was created from
+	ImageID       string    `json:"image_id"`
+	LocalDigest   string    `json:"local_digest,omitempty"`
+	RemoteDigest  string    `json:"remote_digest,omitempty"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	CheckedAt     time.Time `json:"checked_at,omitzero"`
+}
+
+// Report is the result of the latest check.
+type Report struct {
+	Containers       []ContainerStatus `json:"containers"`
+	UpdatesAvailable int               `json:"updates_available"`
+	CheckedAt        *time.Time        `json:"checked_at,omitempty"`
+	NextCheck        *time.Time        `json:"next_check,omitempty"`
+	Checking         bool              `json:"checking"`
+}
+
+// Checker runs update checks on a schedule and on request, and keeps the
+// latest results. It is safe for concurrent use.
+type Checker struct {
+	interval time.Duration
+
+	// runMu serialises checks, so a manual check waits for a scheduled one
+	// that is already running and then runs again.
+	runMu sync.Mutex
+
+	mu        sync.RWMutex
+	results   []ContainerStatus
+	checkedAt time.Time
+	nextCheck time.Time
+	checking  bool
+}
+
+var checker *Checker
+
+// InitChecker creates the checker and, unless cfg disables the schedule,
+// starts checking in the background.
+func InitChecker(cfg *config.Config) {
+	checker = &Checker{interval: cfg.Updates.CheckInterval}
+	if checker.interval > 0 {
+		go checker.schedule()
+	}
+}
+
+func GetChecker() *Checker {
+	return checker
+}
+
+func (c *Checker) schedule() {
+	wait := firstCheckDelay
+	for {
+		c.mu.Lock()
+		c.nextCheck = time.Now().Add(wait).UTC()
+		c.mu.Unlock()
+
+		time.Sleep(wait)
+		if _, err := c.Check(context.Background()); err != nil {
+			slog.Error("Image update check failed", "error", err)
+		}
+		wait = c.interval
+	}
+}
+
+// Report returns the results of the latest check.
+func (c *Checker) Report() Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := Report{Containers: slices.Clone(c.results), Checking: c.checking}
+	if report.Containers == nil {
+		report.Containers = []ContainerStatus{}
+	}
+	for _, status := range report.Containers {
+		if status.Status == StatusUpdateAvailable {
+			report.UpdatesAvailable++
+		}
+	}
+	if !c.checkedAt.IsZero() {
+		checkedAt := c.checkedAt
+		report.CheckedAt = &checkedAt
+	}
+	if c.interval > 0 && !c.nextCheck.IsZero() {
+		nextCheck := c.nextCheck
+		report.NextCheck = &nextCheck
+	}
+	return report
+}
+
+// Status returns the latest result for the container with the given full
+// ID, or an "unchecked" status if it has not been checked yet.
+func (c *Checker) Status(containerID string) ContainerStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, status := range c.results {
+		if status.ContainerID == containerID {
+			return status
+		}
+	}
+	return ContainerStatus{ContainerID: containerID, Status: StatusUnchecked}
+}
+
+// Check checks every running container now and returns the new report.
+func (c *Checker) Check(ctx context.Context) (Report, error) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.setChecking(true)
+	defer c.setChecking(false)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return Report{}, err
+	}
+	defer cli.Close()
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return Report{}, err
+	}
+	// Harbory's own helper containers are not the user's to update.
+	containers = slices.DeleteFunc(containers, func(ctr container.Summary) bool {
+		_, ok := ctr.Labels[helperLabel]
+		return ok
+	})
+
+	var registryConfig *registry.ServiceConfig
+	if info, err := cli.Info(ctx); err == nil {
+		registryConfig = info.RegistryConfig
+	}
+	run := &checkRun{
+		cli:      cli,
+		registry: newRegistryClient(func(host string) bool { return isInsecureRegistry(registryConfig, host) }),
+		images:   map[string]image.InspectResponse{},
+		remotes:  map[string]remoteDigest{},
+	}
+
+	results := make([]ContainerStatus, 0, len(containers))
+	for _, ctr := range containers {
+		results = append(results, run.check(ctx, ctr))
+	}
+	if ctx.Err() != nil {
+		return Report{}, ctx.Err()
+	}
+	slices.SortFunc(results, func(a, b ContainerStatus) int { return strings.Compare(a.ContainerName, b.ContainerName) })
+
+	c.mu.Lock()
+	c.results = results
+	c.checkedAt = time.Now().UTC()
+	c.mu.Unlock()
+
+	return c.Report(), nil
+}
+
+func (c *Checker) setChecking(checking bool) {
+	c.mu.Lock()
+	c.checking = checking
+	c.mu.Unlock()
+}
+
+type remoteDigest struct {
+	digest string
+	err    error
+}
+
+// checkRun caches image and registry lookups for one check, as several
+// containers usually share an image.
+type checkRun struct {
+	cli      *client.Client
+	registry *registryClient
+	images   map[string]image.InspectResponse
+	remotes  map[string]remoteDigest
+}
+
+func (run *checkRun) check(ctx context.Context, ctr container.Summary) ContainerStatus {
+	status := ContainerStatus{
+		ContainerID: ctr.ID,
+		Image:       ctr.Image,
+		ImageID:     ctr.ImageID,
+		CheckedAt:   time.Now().UTC(),
+	}
+	if len(ctr.Names) > 0 {
+		status.ContainerName = strings.TrimPrefix(ctr.Names[0], "/")
+	}
+
+	skip := func(reason string) ContainerStatus {
+		status.Status = StatusSkipped
+		status.Reason = reason
+		return status
+	}
+	fail := func(err error) ContainerStatus {
+		status.Status = StatusError
+		status.Reason = err.Error()
+		return status
+	}
+
+	// The daemon lists the image ID instead of the reference the container
+	// was created from once that reference points to another image, which
+	// is exactly the case of a pulled update.
+	if strings.HasPrefix(ctr.Image, "sha256:") {
+		info, err := run.cli.ContainerInspect(ctx, ctr.ID)
+		if err != nil {
+			return fail(err)
+		}
+		if info.Config != nil {
+			status.Image = info.Config.Image
+		}
+	}
+	if strings.HasPrefix(status.Image, "sha256:") {
+		return skip("the container was created from an image ID")
+	}
+	named, err := reference.ParseNormalizedNamed(status.Image)
+	if err != nil {
+		return skip("the container was created from an image ID")
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return skip("the image is pinned to a digest")
+	}
+	tagged := reference.TagNameOnly(named).(reference.NamedTagged)
+
+	info, ok := run.images[ctr.ImageID]
+	if !ok {
+		info, err = run.cli.ImageInspect(ctx, ctr.ImageID)
+		if err != nil {
+			return fail(err)
+		}
+		run.images[ctr.ImageID] = info
+	}
+	status.LocalDigest = repoDigest(info, tagged)
+	if status.LocalDigest == "" {
+		return skip("the image was not pulled from " + reference.Domain(tagged) + ", so there is no digest to compare")
+	}
+
+	remote, ok := run.remotes[tagged.String()]
+	if !ok {
+		remote.digest, remote.err = run.remoteDigest(ctx, tagged)
+		run.remotes[tagged.String()] = remote
+	}
+	if remote.err != nil {
+		return fail(remote.err)
+	}
+
+	status.RemoteDigest = remote.digest
+	status.Status = StatusUpToDate
+	if status.RemoteDigest != status.LocalDigest {
+		status.Status = StatusUpdateAvailable
+	}
+	return status
+}
+
+// remoteDigest returns the digest named's tag points to now. The daemon is
+// asked first: it reaches the registry from the Docker host, with its own
+// insecure-registry and loopback handling, so a registry published on the
+// host's localhost works even though Harbory runs in a container of its own.
+// Harbory's registry client, which connects from Harbory's network, is the
+// fallback for daemons that will not answer, such as those behind a socket
+// proxy that blocks the distribution endpoint.
+func (run *checkRun) remoteDigest(ctx context.Context, named reference.NamedTagged) (string, error) {
+	encodedAuth, err := encodedRegistryAuth(reference.Domain(named))
+	if err != nil {
+		return "", err
+	}
+
+	inspect, err := run.cli.DistributionInspect(ctx, named.String(), encodedAuth)
+	if err == nil {
+		return inspect.Descriptor.Digest.String(), nil
+	}
+	if cerrdefs.IsNotFound(err) || ctx.Err() != nil {
+		return "", err
+	}
+
+	digest, directErr := run.registry.manifestDigest(ctx, named)
+	if directErr != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// encodedRegistryAuth returns the stored credentials for host in the form
+// the daemon expects, or "" if there are none.
+func encodedRegistryAuth(host string) (string, error) {
+	auth, ok := credentials.Resolve(host)
+	if !ok {
+		return "", nil
+	}
+
+	serverAddress := host
+	if host == "docker.io" {
+		serverAddress = "https://index.docker.io/v1/"
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		IdentityToken: auth.IdentityToken,
+		ServerAddress: serverAddress,
+	})
+}
+
+// repoDigest returns the digest the image was pulled by from named's
+// repository.
+func repoDigest(info image.InspectResponse, named reference.Named) string {
+	for _, raw := range info.RepoDigests {
+		ref, err := reference.ParseNormalizedNamed(raw)
+		if err != nil || ref.Name() != named.Name() {
+			continue
+		}
+		if digested, ok := ref.(reference.Digested); ok {
+			return digested.Digest().String()
+		}
+	}
+	return ""
+}
+
+// isInsecureRegistry reports whether the daemon treats host as insecure:
+// listed in insecure-registries, or an address in one of its insecure CIDRs,
+// which include the loopback range by default. host is resolved from
+// Harbory's network, which may differ from the daemon's; it only serves the
+// fallback registry client.
+func isInsecureRegistry(config *registry.ServiceConfig, host string) bool {
+	if config == nil {
+		return false
+	}
+	if index, ok := config.IndexConfigs[host]; ok {
+		return !index.Secure
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(hostname); ip != nil {
+		ips = []net.IP{ip}
+	} else if addrs, err := net.LookupIP(hostname); err == nil {
+		ips = addrs
+	}
+
+	for _, cidr := range config.InsecureRegistryCIDRs {
+		for _, ip := range ips {
+			if (*net.IPNet)(cidr).Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
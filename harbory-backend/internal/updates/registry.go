@@ -0,0 +1,262 @@
+package updates
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/credentials"
+	"github.com/distribution/reference"
+)
+
+// manifestMediaTypes are the manifest types a registry may answer with.
+// Multi-platform indexes come first: pulling by tag records the index
+// digest, so that is the digest to compare with.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// errTagNotFound is returned when the registry does not know the tag.
+var errTagNotFound = errors.New("tag not found in registry")
+
+// registryClient asks registries for the digest a tag currently points to,
+// using the Registry HTTP API v2. It connects from Harbory's own network, so
+// it is only the fallback for when the daemon cannot be asked; see
+// checkRun.remoteDigest.
+type registryClient struct {
+	secure   *http.Client
+	insecure *http.Client
+	// isInsecure reports whether the daemon treats host as an insecure
+	// registry, which may use plain HTTP or an untrusted certificate.
+	isInsecure func(host string) bool
+}
+
+func newRegistryClient(isInsecure func(host string) bool) *registryClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return &registryClient{
+		secure:     &http.Client{Timeout: 30 * time.Second},
+		insecure:   &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		isInsecure: isInsecure,
+	}
+}
+
+// manifestDigest returns the digest of the manifest named points to. Like
+// the daemon, it tries HTTPS first and falls back to plain HTTP only for
+// insecure registries.
+func (c *registryClient) manifestDigest(ctx context.Context, named reference.NamedTagged) (string, error) {
+	host := reference.Domain(named)
+	endpoint := host
+	if host == "docker.io" {
+		endpoint = "registry-1.docker.io"
+	}
+	path := fmt.Sprintf("/v2/%s/manifests/%s", reference.Path(named), named.Tag())
+
+	auth, _ := credentials.Resolve(host)
+
+	if !c.isInsecure(host) {
+		return c.fetchDigest(ctx, c.secure, "https://"+endpoint+path, auth)
+	}
+
+	digest, err := c.fetchDigest(ctx, c.insecure, "https://"+endpoint+path, auth)
+	// Only a failed connection or TLS handshake means the registry may be
+	// speaking plain HTTP; a registry's own error answers are final.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && ctx.Err() == nil {
+		return c.fetchDigest(ctx, c.insecure, "http://"+endpoint+path, auth)
+	}
+	return digest, err
+}
+
+// fetchDigest sends a HEAD request for the manifest, authenticating when
+// the registry asks for it. Registries that leave out the digest header are
+// asked for the manifest itself, whose hash is the digest.
+func (c *registryClient) fetchDigest(ctx context.Context, httpClient *http.Client, manifestURL string, auth credentials.Auth) (string, error) {
+	resp, err := c.request(ctx, httpClient, http.MethodHead, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	authorization := ""
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err = c.authorize(ctx, httpClient, resp.Header.Get("WWW-Authenticate"), auth)
+		if err != nil {
+			return "", err
+		}
+		resp, err = c.request(ctx, httpClient, http.MethodHead, manifestURL, authorization)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+
+	if err := registryStatusError(resp); err != nil {
+		return "", err
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	resp, err = c.request(ctx, httpClient, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := registryStatusError(resp); err != nil {
+		return "", err
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *registryClient) request(ctx context.Context, httpClient *http.Client, method, target, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return httpClient.Do(req)
+}
+
+// authorize answers a registry's authentication challenge and returns the
+// Authorization header to retry with: the credentials themselves for Basic
+// auth, or a token from the registry's token service for Bearer auth.
+func (c *registryClient) authorize(ctx context.Context, httpClient *http.Client, challenge string, auth credentials.Auth) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if auth.Username == "" {
+			return "", errors.New("the registry requires credentials and none are stored for it")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(auth.Username, auth.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported registry authentication %q", scheme)
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("registry token challenge has no realm")
+	}
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+
+	var req *http.Request
+	var err error
+	if auth.IdentityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", auth.IdentityToken)
+		query.Set("client_id", "harbory")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(query.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+		if err == nil && auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := registryStatusError(resp); err != nil {
+		return "", fmt.Errorf("requesting registry token: %w", err)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("reading registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", errors.New("registry token service returned no token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func registryStatusError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return errTagNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("registry denied access (%s)", resp.Status)
+	default:
+		return fmt.Errorf("registry answered %s", resp.Status)
+	}
+}
+
+// parseChallenge splits a WWW-Authenticate header such as `Bearer
+// realm="https://auth.docker.io/token",scope="repository:a:pull,push"` into
+// its scheme and parameters. Quoted values may contain commas.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+	}
+
+	return scheme, params
+}
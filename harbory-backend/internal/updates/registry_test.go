@@ -0,0 +1,218 @@
+package updates
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PreetinderSinghBadesha/harbory/internal/credentials"
+	"github.com/distribution/reference"
+)
+
+const (
+	testManifest = `{"schemaVersion":2}`
+	testDigest   = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	manifestPath = "/v2/team/app/manifests/1.0"
+)
+
+// newTestRegistry serves manifestPath with manifest, behind authorize, which
+// answers the requests that may not go through itself. Requests for other
+// paths go to extra.
+func newTestRegistry(t *testing.T, authorize func(w http.ResponseWriter, r *http.Request) bool, manifest http.HandlerFunc, extra http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != manifestPath {
+			if extra == nil {
+				http.NotFound(w, r)
+				return
+			}
+			extra(w, r)
+			return
+		}
+		if authorize != nil && !authorize(w, r) {
+			return
+		}
+		manifest(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func digestManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Content-Digest", testDigest)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write([]byte(testManifest))
+	}
+}
+
+func fetch(t *testing.T, srv *httptest.Server, auth credentials.Auth) (string, error) {
+	t.Helper()
+	c := newRegistryClient(func(string) bool { return false })
+	return c.fetchDigest(t.Context(), srv.Client(), srv.URL+manifestPath, auth)
+}
+
+func TestFetchDigestFromHeader(t *testing.T) {
+	var methods []string
+	srv := newTestRegistry(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		digestManifest(w, r)
+	}, nil)
+
+	digest, err := fetch(t, srv, credentials.Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("digest = %s, want %s", digest, testDigest)
+	}
+	if !reflect.DeepEqual(methods, []string{http.MethodHead}) {
+		t.Fatalf("requests = %v, want a single HEAD", methods)
+	}
+}
+
+func TestFetchDigestHashesManifest(t *testing.T) {
+	var methods []string
+	srv := newTestRegistry(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(testManifest))
+		}
+	}, nil)
+
+	digest, err := fetch(t, srv, credentials.Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(testManifest))
+	if want := "sha256:" + hex.EncodeToString(sum[:]); digest != want {
+		t.Fatalf("digest = %s, want %s", digest, want)
+	}
+	if !reflect.DeepEqual(methods, []string{http.MethodHead, http.MethodGet}) {
+		t.Fatalf("requests = %v, want HEAD then GET", methods)
+	}
+}
+
+func TestFetchDigestTagNotFound(t *testing.T) {
+	srv := newTestRegistry(t, nil, http.NotFound, nil)
+
+	if _, err := fetch(t, srv, credentials.Auth{}); err != errTagNotFound {
+		t.Fatalf("err = %v, want errTagNotFound", err)
+	}
+}
+
+func TestFetchDigestBasicChallenge(t *testing.T) {
+	srv := newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if user, password, ok := r.BasicAuth(); ok && user == "alice" && password == "secret" {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}, digestManifest, nil)
+
+	digest, err := fetch(t, srv, credentials.Auth{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("digest = %s, want %s", digest, testDigest)
+	}
+
+	if _, err := fetch(t, srv, credentials.Auth{}); err == nil {
+		t.Fatal("fetchDigest succeeded without credentials")
+	}
+}
+
+func TestFetchDigestBearerChallenge(t *testing.T) {
+	var srv *httptest.Server
+	srv = newTestRegistry(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") == "Bearer pull-token" {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry.test",scope="repository:team/app:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}, digestManifest, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		user, password, _ := r.BasicAuth()
+		if query.Get("service") != "registry.test" || query.Get("scope") != "repository:team/app:pull" || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"pull-token"}`))
+	})
+
+	digest, err := fetch(t, srv, credentials.Auth{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("digest = %s, want %s", digest, testDigest)
+	}
+}
+
+func TestManifestDigestFallsBackToHTTPForInsecureRegistries(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	srv := newTestRegistry(t, nil, digestManifest, nil)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	named, err := reference.ParseNormalizedNamed(host + "/team/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newRegistryClient(func(h string) bool { return h == host })
+	digest, err := c.manifestDigest(t.Context(), named.(reference.NamedTagged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Fatalf("digest = %s, want %s", digest, testDigest)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			header: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			scheme: "Bearer",
+			params: map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			header: `Bearer realm="https://ghcr.io/token", scope="repository:a/b:pull,push"`,
+			scheme: "Bearer",
+			params: map[string]string{"realm": "https://ghcr.io/token", "scope": "repository:a/b:pull,push"},
+		},
+		{
+			header: `Basic realm=registry,charset="UTF-8"`,
+			scheme: "Basic",
+			params: map[string]string{"realm": "registry", "charset": "UTF-8"},
+		},
+		{
+			header: `Basic`,
+			scheme: "Basic",
+			params: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.header)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v; want %q, %v", tt.header, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
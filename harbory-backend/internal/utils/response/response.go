@@ -3,6 +3,8 @@ package response
 import (
 	"encoding/json"
 	"net/http"
+
+	cerrdefs "github.com/containerd/errdefs"
 )
 
 type Response struct {
@@ -15,6 +17,18 @@ const (
 	StatusError = "Error"
 )
 
+// FieldError describes a single invalid field in a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationResponse struct {
+	Status string       `json:"status"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
 func WriteJSONResponse(w http.ResponseWriter, status int, data interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -33,6 +47,41 @@ func GeneralErrorResponse(err error) Response {
 	}
 }
 
+func ValidationErrorResponse(fields []FieldError) ValidationResponse {
+	return ValidationResponse{
+		Status: StatusError,
+		Error:  "validation failed",
+		Fields: fields,
+	}
+}
+
+// DockerErrorStatus maps an error returned by the Docker SDK to the HTTP status
+// code that describes it best. Unclassified errors are reported as 500.
+func DockerErrorStatus(err error) int {
+	switch {
+	case cerrdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case cerrdefs.IsConflict(err), cerrdefs.IsAlreadyExists(err):
+		return http.StatusConflict
+	case cerrdefs.IsInvalidArgument(err):
+		return http.StatusBadRequest
+	case cerrdefs.IsUnauthorized(err), cerrdefs.IsPermissionDenied(err):
+		return http.StatusForbidden
+	case cerrdefs.IsNotImplemented(err):
+		return http.StatusNotImplemented
+	case cerrdefs.IsUnavailable(err):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteDockerError writes err as a GeneralErrorResponse using the status code
+// returned by DockerErrorStatus.
+func WriteDockerError(w http.ResponseWriter, err error) error {
+	return WriteJSONResponse(w, DockerErrorStatus(err), GeneralErrorResponse(err))
+}
+
 func SendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 w.Header().Set("Content-Type", "application/json")
 w.WriteHeader(statusCode)
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// KeepAliveInterval is how often long-lived streams send a comment frame so
+// idle connections are not dropped by proxies sitting in front of Harbory.
+const KeepAliveInterval = 15 * time.Second
+
+// IsWebSocketRequest reports whether r asks for a WebSocket upgrade, letting a
+// single route serve both WebSocket and Server-Sent Events clients.
+func IsWebSocketRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// KeepAliveWebSocket sends a ping frame every KeepAliveInterval until ctx is
+// done or a ping fails, the WebSocket counterpart of SSEWriter.KeepAlive. It
+// pings through ws.Write, so callers must send their own messages with a
+// websocket.Codec such as websocket.JSON, never with ws.Write.
+func KeepAliveWebSocket(ctx context.Context, ws *websocket.Conn) {
+	ws.PayloadType = websocket.PingFrame
+	ticker := time.NewTicker(KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ws.Write(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// DisableWriteDeadline lifts the server-wide WriteTimeout for a single
+// response so it can stay open for as long as the client is reading.
+// Hijacked (WebSocket) connections already have their deadlines cleared by
+// net/http and do not need this.
+func DisableWriteDeadline(w http.ResponseWriter) error {
+	return http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// SSEWriter writes Server-Sent Events to an http.ResponseWriter. It is safe
+// for concurrent use.
+type SSEWriter struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	mu     sync.Mutex
+	closed bool
+}
+
+// ErrClosed is returned when writing to an SSEWriter after Close.
+var ErrClosed = errors.New("stream closed")
+
+// NewSSEWriter prepares w for an event stream: it removes the write deadline,
+// sends the event-stream headers and flushes them to the client.
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &SSEWriter{w: w, rc: rc}, nil
+}
+
+// Send writes data as a JSON encoded event. An empty event name sends an
+// unnamed event, which browsers deliver to the EventSource "message" handler.
+func (s *SSEWriter) Send(event string, data interface{}) error {
+	return s.SendWithID("", event, data)
+}
+
+// SendWithID is Send with an event ID. Browsers remember the last ID they
+// saw and send it back in the Last-Event-ID header when they reconnect.
+func (s *SSEWriter) SendWithID(id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+
+	return s.rc.Flush()
+}
+
+// Close stops all further writes. Handlers must call it before returning so a
+// KeepAlive goroutine never touches the ResponseWriter afterwards.
+func (s *SSEWriter) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+// KeepAlive sends a comment frame every KeepAliveInterval until ctx is done
+// or the writer is closed.
+func (s *SSEWriter) KeepAlive(ctx context.Context) {
+	ticker := time.NewTicker(KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := ErrClosed
+			if !s.closed {
+				if _, err = fmt.Fprint(s.w, ": keep-alive\n\n"); err == nil {
+					err = s.rc.Flush()
+				}
+			}
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
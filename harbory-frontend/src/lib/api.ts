@@ -18,6 +18,14 @@ const getApiBaseUrl = () => {
 
 const API_BASE_URL = getApiBaseUrl();
 
+export interface ListPage<T> {
+  items: T[];
+  total: number;
+  limit?: number;
+  next_cursor?: string;
+  warnings?: string[];
+}
+
 interface ApiResponse<T> {
   status: string;
   data?: T;
@@ -86,27 +94,27 @@ async function fetchApi<T>(endpoint: string, options: RequestInit = {}): Promise
 }
 
 export const containerApi = {
-  getAll: () => fetchApi<any[]>('/containers'),
+  getAll: () => fetchApi<ListPage<any>>('/containers?all=true'),
   getById: (id: string) => fetchApi<any>(`/containers/${id}`)
 };
 
 export const imageApi = {
-  getAll: () => fetchApi<any[]>('/images'),
+  getAll: () => fetchApi<ListPage<any>>('/images'),
   getById: (id: string) => fetchApi<any>(`/images/${id}`)
 };
 
 export const volumeApi = {
-  getAll: () => fetchApi<any>('/volumes'),
+  getAll: () => fetchApi<ListPage<any>>('/volumes'),
   getById: (name: string) => fetchApi<any>(`/volumes/${name}`)
 };
 
 export const networkApi = {
-  getAll: () => fetchApi<any[]>('/networks'),
+  getAll: () => fetchApi<ListPage<any>>('/networks'),
   getById: (id: string) => fetchApi<any>(`/networks/${id}`)
 };
 
 export const nodeApi = {
-  getAll: () => fetchApi<any[]>('/nodes'),
+  getAll: () => fetchApi<ListPage<any>>('/nodes'),
   getById: (id: string) => fetchApi<any>(`/nodes/${id}`)
 };
 